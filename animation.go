@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// --- Animations ---
+
+const (
+	// maxAnimationFrames caps how many frames we decode and send per post.
+	maxAnimationFrames = 120
+	// maxAnimationPixels caps the pixels decoded from all frames of a GIF
+	// or APNG together. Longer animations are cut short.
+	maxAnimationPixels = 1 << 27
+	// maxAnimationFPS caps the playback rate, frames shorter than this are
+	// merged into the next one.
+	maxAnimationFPS = 15
+	// defaultFrameDelay is used for frames without a delay, like browsers do.
+	defaultFrameDelay = 100 * time.Millisecond
+	// kittyAnimationID is the first image id used for animation frames.
+	kittyAnimationID = 100
+)
+
+// errNoFFmpeg is returned when a video can't be previewed without ffmpeg.
+var errNoFFmpeg = errors.New("ffmpeg is required to preview videos")
+
+// animationFrame is a single decoded frame and how long it's shown for.
+type animationFrame struct {
+	img   image.Image
+	delay time.Duration
+}
+
+// renderedAnimation holds the encoded frames of an animation. Setup is sent
+// once before the first frame; for kitty it transmits every frame so that
+// playback only has to swap placements.
+type renderedAnimation struct {
+	proto  imageProtocol
+	setup  string
+	frames []string
+	delays []time.Duration
+}
+
+// isVideo reports whether a file extension needs ffmpeg to be decoded.
+func isVideo(ext string) bool {
+	switch ext {
+	case ".webm", ".mp4", ".webp":
+		return true
+	}
+	return false
+}
+
+// decodeFrames decodes a still or animated image. GIFs and APNGs yield up to
+// maxAnimationFrames frames sampled evenly from the animation, scaled down to
+// fit within maxW x maxH pixels. Anything else yields a single frame.
+func decodeFrames(data []byte, maxW, maxH int) ([]animationFrame, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return decodeGIF(data, maxW, maxH)
+	case isAPNG(data):
+		return decodeAPNG(data, maxW, maxH)
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	return []animationFrame{{img: img}}, nil
+}
+
+// decodeGIF composites the frames of a GIF, honouring their disposal methods.
+func decodeGIF(data []byte, maxW, maxH int) ([]animationFrame, error) {
+	data, err := truncateGIF(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode gif: %w", err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode gif: %w", err)
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	sampler := newFrameSampler(len(g.Image), maxW, maxH)
+	for i, frame := range g.Image {
+		var previous *image.RGBA
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		sampler.add(i, canvas, time.Duration(g.Delay[i])*10*time.Millisecond)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return sampler.frames, nil
+}
+
+// truncateGIF cuts a GIF short after as many frames as maxAnimationPixels
+// allows, so that gif.DecodeAll doesn't decode all of a long animation. It
+// walks the blocks of the file without decompressing them.
+func truncateGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 {
+		return nil, errors.New("truncated gif header")
+	}
+	width := int(binary.LittleEndian.Uint16(data[6:]))
+	height := int(binary.LittleEndian.Uint16(data[8:]))
+	if width*height > maxAnimationPixels {
+		return nil, fmt.Errorf("gif canvas of %dx%d pixels is too large", width, height)
+	}
+	limit := max(maxAnimationPixels/max(width*height, 1), 1)
+
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&7 + 1)
+	}
+	// skipSubBlocks returns the position after a chain of data sub-blocks.
+	skipSubBlocks := func(pos int) int {
+		for pos < len(data) && data[pos] != 0 {
+			pos += int(data[pos]) + 1
+		}
+		return pos + 1
+	}
+	for frames := 0; pos < len(data); {
+		switch data[pos] {
+		case 0x21: // Extension.
+			pos = skipSubBlocks(pos + 2)
+		case 0x2c: // Image descriptor.
+			if pos+10 > len(data) {
+				return data, nil
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&7 + 1)
+			}
+			pos = skipSubBlocks(pos + 1) // After the LZW code size.
+			if frames++; frames == limit && pos < len(data) {
+				return append(data[:pos:pos], 0x3b), nil
+			}
+		default: // Trailer, or garbage the decoder will complain about.
+			return data, nil
+		}
+	}
+	return data, nil
+}
+
+// frameSampler keeps evenly spaced frames of an animation of n frames as
+// they're composited, so at most maxAnimationFrames canvases are copied.
+// Frames in between add their delay to the kept frame before them.
+type frameSampler struct {
+	step       int
+	maxW, maxH int
+	frames     []animationFrame
+}
+
+func newFrameSampler(n, maxW, maxH int) *frameSampler {
+	return &frameSampler{
+		step: max((n+maxAnimationFrames-1)/maxAnimationFrames, 1),
+		maxW: maxW,
+		maxH: maxH,
+	}
+}
+
+// add offers frame i, the current state of canvas, to the sampler.
+func (s *frameSampler) add(i int, canvas *image.RGBA, delay time.Duration) {
+	if delay <= 10*time.Millisecond {
+		delay = defaultFrameDelay
+	}
+	if i%s.step != 0 && len(s.frames) > 0 {
+		s.frames[len(s.frames)-1].delay += delay
+		return
+	}
+	s.frames = append(s.frames, animationFrame{img: shrinkRGBA(canvas, s.maxW, s.maxH), delay: delay})
+}
+
+// shrinkRGBA returns a copy of img scaled down to fit within maxW x maxH
+// pixels, keeping its aspect ratio. A zero size leaves it as is.
+func shrinkRGBA(img *image.RGBA, maxW, maxH int) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if maxW <= 0 || maxH <= 0 || (w <= maxW && h <= maxH) {
+		return cloneRGBA(img)
+	}
+	if w*maxH >= h*maxW {
+		return scaleImage(img, maxW, max(h*maxW/w, 1))
+	}
+	return scaleImage(img, max(w*maxH/h, 1), maxH)
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	c := image.NewRGBA(img.Rect)
+	copy(c.Pix, img.Pix)
+	return c
+}
+
+// --- APNG ---
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngChunk is a raw PNG chunk without its length and CRC.
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// apngFrameControl is the decoded content of an fcTL chunk.
+type apngFrameControl struct {
+	width, height    int
+	xOffset, yOffset int
+	delay            time.Duration
+	dispose, blend   byte
+}
+
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a png")
+	}
+	data = data[len(pngSignature):]
+
+	var chunks []pngChunk
+	for len(data) >= 12 {
+		length := int(binary.BigEndian.Uint32(data))
+		if length > len(data)-12 {
+			return nil, errors.New("truncated png chunk")
+		}
+		chunks = append(chunks, pngChunk{typ: string(data[4:8]), data: data[8 : 8+length]})
+		data = data[12+length:]
+	}
+	return chunks, nil
+}
+
+// isAPNG reports whether data is a PNG with an animation control chunk
+// before its image data.
+func isAPNG(data []byte) bool {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return false
+	}
+	for _, c := range chunks {
+		switch c.typ {
+		case "acTL":
+			return true
+		case "IDAT":
+			return false
+		}
+	}
+	return false
+}
+
+// decodeAPNG splits an APNG into standalone PNGs, one per frame, decodes them
+// with image/png and composites them using their dispose and blend ops.
+func decodeAPNG(data []byte, maxW, maxH int) ([]animationFrame, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var ihdr []byte
+	var shared []pngChunk // Palette, transparency, gamma etc.
+	type rawFrame struct {
+		ctl  apngFrameControl
+		data [][]byte
+	}
+	var raw []*rawFrame
+	var current *rawFrame
+	for _, c := range chunks {
+		switch c.typ {
+		case "IHDR":
+			ihdr = c.data
+		case "acTL", "IEND":
+		case "fcTL":
+			if len(c.data) < 26 {
+				return nil, errors.New("invalid fcTL chunk")
+			}
+			num := binary.BigEndian.Uint16(c.data[20:])
+			den := binary.BigEndian.Uint16(c.data[22:])
+			if den == 0 {
+				den = 100
+			}
+			current = &rawFrame{ctl: apngFrameControl{
+				width:   int(binary.BigEndian.Uint32(c.data[4:])),
+				height:  int(binary.BigEndian.Uint32(c.data[8:])),
+				xOffset: int(binary.BigEndian.Uint32(c.data[12:])),
+				yOffset: int(binary.BigEndian.Uint32(c.data[16:])),
+				delay:   time.Duration(num) * time.Second / time.Duration(den),
+				dispose: c.data[24],
+				blend:   c.data[25],
+			}}
+			raw = append(raw, current)
+		case "IDAT":
+			// The default image is only part of the animation if an fcTL
+			// came before it.
+			if current != nil {
+				current.data = append(current.data, c.data)
+			}
+		case "fdAT":
+			if current != nil && len(c.data) >= 4 {
+				current.data = append(current.data, c.data[4:])
+			}
+		default:
+			if current == nil {
+				shared = append(shared, c)
+			}
+		}
+	}
+	if len(ihdr) < 13 || len(raw) == 0 {
+		return nil, errors.New("invalid apng")
+	}
+
+	width := int(binary.BigEndian.Uint32(ihdr[0:]))
+	height := int(binary.BigEndian.Uint32(ihdr[4:]))
+	if width <= 0 || height <= 0 || width > maxAnimationPixels/height {
+		return nil, fmt.Errorf("apng canvas of %dx%d pixels is too large", width, height)
+	}
+
+	// Check every frame fits the canvas before allocating anything, and stop
+	// once maxAnimationPixels are used up.
+	pixels := 0
+	for i, f := range raw {
+		c := f.ctl
+		if c.width <= 0 || c.height <= 0 || c.xOffset < 0 || c.yOffset < 0 ||
+			c.width > width-c.xOffset || c.height > height-c.yOffset {
+			return nil, fmt.Errorf("apng frame %d of %dx%d at %d,%d is outside the %dx%d canvas", i, c.width, c.height, c.xOffset, c.yOffset, width, height)
+		}
+		if pixels += c.width * c.height; pixels > maxAnimationPixels && i > 0 {
+			raw = raw[:i]
+			break
+		}
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	sampler := newFrameSampler(len(raw), maxW, maxH)
+	for i, f := range raw {
+		frameIHDR := append([]byte(nil), ihdr...)
+		binary.BigEndian.PutUint32(frameIHDR[0:], uint32(f.ctl.width))
+		binary.BigEndian.PutUint32(frameIHDR[4:], uint32(f.ctl.height))
+
+		var buf bytes.Buffer
+		buf.Write(pngSignature)
+		writePNGChunk(&buf, "IHDR", frameIHDR)
+		for _, c := range shared {
+			writePNGChunk(&buf, c.typ, c.data)
+		}
+		for _, d := range f.data {
+			writePNGChunk(&buf, "IDAT", d)
+		}
+		writePNGChunk(&buf, "IEND", nil)
+
+		img, err := png.Decode(&buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode apng frame: %w", err)
+		}
+
+		rect := image.Rect(f.ctl.xOffset, f.ctl.yOffset, f.ctl.xOffset+f.ctl.width, f.ctl.yOffset+f.ctl.height)
+		var previous *image.RGBA
+		if f.ctl.dispose == 2 {
+			previous = cloneRGBA(canvas)
+		}
+		op := draw.Src
+		if f.ctl.blend == 1 {
+			op = draw.Over
+		}
+		draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+		sampler.add(i, canvas, f.ctl.delay)
+
+		switch f.ctl.dispose {
+		case 1:
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		case 2:
+			canvas = previous
+		}
+	}
+	return sampler.frames, nil
+}
+
+func writePNGChunk(w io.Writer, typ string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], typ)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	w.Write(header[:])
+	w.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// --- Videos ---
+
+// decodeVideoFrames extracts up to maxAnimationFrames frames at no more than
+// maxAnimationFPS from a video using ffmpeg, scaled down to fit within
+// maxW x maxH pixels.
+func decodeVideoFrames(ctx context.Context, data []byte, ext string, maxW, maxH int) ([]animationFrame, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, errNoFFmpeg
+	}
+
+	// Containers like mp4 may need seeking, so ffmpeg gets a real file.
+	tmpfile, err := os.CreateTemp("", "tmp-*"+ext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write(data); err != nil {
+		tmpfile.Close()
+		return nil, fmt.Errorf("failed to save video to temp file: %w", err)
+	}
+	tmpfile.Close()
+
+	filter := fmt.Sprintf("fps=%d,scale=w=%d:h=%d:force_original_aspect_ratio=decrease", maxAnimationFPS, max(maxW, 1), max(maxH, 1))
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-v", "error",
+		"-i", tmpfile.Name(),
+		"-vf", filter,
+		"-frames:v", fmt.Sprint(maxAnimationFrames),
+		"-f", "image2pipe", "-c:v", "png", "-",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	var frames []animationFrame
+	rd := bufio.NewReader(stdout)
+	for {
+		if _, err := rd.Peek(1); err != nil {
+			break
+		}
+		img, err := png.Decode(rd)
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil, fmt.Errorf("failed to decode video frame: %w", err)
+		}
+		frames = append(frames, animationFrame{img: img, delay: time.Second / maxAnimationFPS})
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("ffmpeg produced no frames")
+	}
+	return frames, nil
+}
+
+// --- Playback ---
+
+// limitFrames enforces maxAnimationFPS by merging frames that are too short
+// into the following one, then drops frames evenly until at most
+// maxAnimationFrames remain.
+func limitFrames(frames []animationFrame) []animationFrame {
+	minDelay := time.Second / maxAnimationFPS
+
+	var limited []animationFrame
+	var carry time.Duration
+	for i, f := range frames {
+		if f.delay <= 10*time.Millisecond {
+			f.delay = defaultFrameDelay
+		}
+		f.delay += carry
+		carry = 0
+		if f.delay < minDelay && i < len(frames)-1 {
+			carry = f.delay
+			continue
+		}
+		limited = append(limited, f)
+	}
+
+	if len(limited) <= maxAnimationFrames {
+		return limited
+	}
+	step := (len(limited) + maxAnimationFrames - 1) / maxAnimationFrames
+	var sampled []animationFrame
+	for i := 0; i < len(limited); i += step {
+		f := limited[i]
+		for j := i + 1; j < min(i+step, len(limited)); j++ {
+			f.delay += limited[j].delay
+		}
+		sampled = append(sampled, f)
+	}
+	return sampled
+}
+
+// renderAnimation encodes every frame for the given protocol.
+func renderAnimation(frames []animationFrame, proto imageProtocol, box placement, cell cellSize) (*renderedAnimation, error) {
+	frames = limitFrames(frames)
+	anim := &renderedAnimation{proto: proto}
+	if proto != protocolKitty {
+		for _, f := range frames {
+			out, err := renderImage(f.img, proto, box, cell)
+			if err != nil {
+				return nil, err
+			}
+			anim.frames = append(anim.frames, out)
+			anim.delays = append(anim.delays, f.delay)
+		}
+		return anim, nil
+	}
+
+	// Kitty keeps every frame as a separate image, and each tick moves the
+	// placement from the previous frame's image to the current one.
+	var setup strings.Builder
+	setup.WriteString(kittyDeleteAll())
+	for i, f := range frames {
+		b := f.img.Bounds()
+		fit := fitImage(b.Dx(), b.Dy(), box, cell)
+		if fit.cols == 0 || fit.rows == 0 {
+			return nil, fmt.Errorf("no room to draw image in %dx%d cells", box.cols, box.rows)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, scaleImage(f.img, fit.pxW, fit.pxH)); err != nil {
+			return nil, fmt.Errorf("failed to encode image: %w", err)
+		}
+		setup.WriteString(kittyTransmit(fmt.Sprintf("a=t,f=100,i=%d,q=2", kittyAnimationID+i), buf.Bytes()))
+
+		prev := kittyAnimationID + (i+len(frames)-1)%len(frames)
+		anim.frames = append(anim.frames,
+			kittyCommand(fmt.Sprintf("a=d,d=i,i=%d,q=2", prev), "")+
+				moveCursor(fit.x, fit.y)+
+				kittyCommand(fmt.Sprintf("a=p,i=%d,p=1,c=%d,r=%d,z=%d,C=1,q=2", kittyAnimationID+i, fit.cols, fit.rows, kittyZIndex), ""),
+		)
+		anim.delays = append(anim.delays, f.delay)
+	}
+	anim.setup = setup.String()
+	return anim, nil
+}
+
+// frameContent returns what to put in the preview viewport for frame i. The
+// setup is only included the first time the animation is shown.
+func (a *renderedAnimation) frameContent(i int, withSetup bool) string {
+	content := a.frames[i]
+	if withSetup {
+		content = a.setup + content
+	}
+	if !a.proto.graphical() {
+		return content
+	}
+	return fmt.Sprintf("\x1b[s%s\x1b[u", content)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodeTestGIF(t *testing.T, frames, w, h int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		img := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		img.Pix[i%len(img.Pix)] = 1
+		g.Image = append(g.Image, img)
+		g.Delay = append(g.Delay, 5)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeGIFSamplesFrames(t *testing.T) {
+	frames, err := decodeGIF(encodeTestGIF(t, 3*maxAnimationFrames, 8, 4), 4, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != maxAnimationFrames {
+		t.Errorf("decoded %d frames, want %d", len(frames), maxAnimationFrames)
+	}
+	for i, f := range frames {
+		if b := f.img.Bounds(); b.Dx() != 4 || b.Dy() != 2 {
+			t.Fatalf("frame %d is %dx%d, want it scaled down to 4x2", i, b.Dx(), b.Dy())
+		}
+		// Each kept frame stands in for three frames of 50ms.
+		if f.delay != 150*time.Millisecond {
+			t.Fatalf("frame %d lasts %s, want 150ms", i, f.delay)
+		}
+	}
+}
+
+func TestTruncateGIF(t *testing.T) {
+	// Frames of 1024x1024 pixels, so only maxAnimationPixels>>20 fit.
+	data := encodeTestGIF(t, maxAnimationPixels>>20+5, 1024, 1024)
+	truncated, err := truncateGIF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatalf("truncated gif doesn't decode: %v", err)
+	}
+	if want := maxAnimationPixels >> 20; len(g.Image) != want {
+		t.Errorf("truncated gif has %d frames, want %d", len(g.Image), want)
+	}
+
+	short := encodeTestGIF(t, 3, 16, 16)
+	if truncated, err := truncateGIF(short); err != nil || !bytes.Equal(truncated, short) {
+		t.Errorf("a short gif was changed: %v", err)
+	}
+}
+
+// encodeTestAPNG builds an APNG of one frame with the given frame control.
+func encodeTestAPNG(canvasW, canvasH, frameW, frameH, x, y int) []byte {
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(canvasW))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(canvasH))
+	ihdr[8] = 8 // Bit depth.
+	ihdr[9] = 6 // RGBA.
+	writePNGChunk(&buf, "IHDR", ihdr)
+	writePNGChunk(&buf, "acTL", make([]byte, 8))
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[4:], uint32(frameW))
+	binary.BigEndian.PutUint32(fctl[8:], uint32(frameH))
+	binary.BigEndian.PutUint32(fctl[12:], uint32(x))
+	binary.BigEndian.PutUint32(fctl[16:], uint32(y))
+	writePNGChunk(&buf, "fcTL", fctl)
+	writePNGChunk(&buf, "IDAT", []byte("not image data"))
+	writePNGChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+func TestDecodeAPNGRejectsFramesOutsideCanvas(t *testing.T) {
+	tests := []struct {
+		name                       string
+		canvasW, canvasH           int
+		frameW, frameH, xOff, yOff int
+		wantErr                    string
+	}{
+		{"wider than canvas", 10, 10, 11, 10, 0, 0, "outside"},
+		{"offset past the edge", 10, 10, 5, 5, 6, 0, "outside"},
+		{"huge offset", 10, 10, 5, 5, 0, 1 << 31, "outside"},
+		{"empty frame", 10, 10, 0, 5, 0, 0, "outside"},
+		{"huge canvas", 1 << 20, 1 << 20, 1, 1, 0, 0, "too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeTestAPNG(tt.canvasW, tt.canvasH, tt.frameW, tt.frameH, tt.xOff, tt.yOff)
+			if !isAPNG(data) {
+				t.Fatal("test data isn't an apng")
+			}
+			_, err := decodeAPNG(data, 0, 0)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- e621 Login ---
+//
+// Users can log in with their e621 username and API key. Requests to e621 are
+// then sent with HTTP Basic auth. The API key is only stored encrypted, see
+// settings.go, and must never end up in the log.
+
+var errInvalidLogin = errors.New("invalid username or API key")
+
+// credentials are an e621 username and API key.
+type credentials struct {
+	username string
+	apiKey   string
+}
+
+// String keeps the API key out of anything that formats credentials.
+func (c credentials) String() string {
+	return fmt.Sprintf("%s:<hidden>", c.username)
+}
+
+func (c credentials) GoString() string {
+	return c.String()
+}
+
+// e621User is the part of a user's profile we care about. The blacklist is
+// only included when the user requests their own profile.
+type e621User struct {
+	ID              int    `json:"id"`
+	Name            string `json:"name"`
+	BlacklistedTags string `json:"blacklisted_tags"`
+}
+
+// basicAuthTransport adds credentials to requests to e621, and only to e621.
+type basicAuthTransport struct {
+	creds credentials
+	base  http.RoundTripper
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isE621Host(req.URL.Hostname()) {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(t.creds.username, t.creds.apiKey)
+	return t.base.RoundTrip(req)
+}
+
+// isE621Host reports whether host belongs to e621, including its static file
+// servers, e926 and the configured mirrors.
+func isE621Host(host string) bool {
+	for _, domain := range []string{"e621.net", "e926.net"} {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return apiMirrors.hasHost(host) || safeAPIMirrors.hasHost(host)
+}
+
+// authenticatedClient returns a client like upstreamClient that logs in with
+// creds. It shares upstreamClient's connection pool.
+func authenticatedClient(creds credentials) *http.Client {
+	base := upstreamClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &http.Client{
+		Timeout:   upstreamClient.Timeout,
+		Transport: &basicAuthTransport{creds: creds, base: base},
+	}
+}
+
+type loginResultMsg struct {
+	creds    credentials
+	user     e621User
+	err      error
+	restored bool // The credentials were loaded from the user's settings.
+}
+
+// validateLoginCmd checks creds by fetching the user's own profile with them.
+// e621 rejects any request with a wrong API key.
+func validateLoginCmd(host string, creds credentials) tea.Cmd {
+	return func() tea.Msg {
+		endpoint := fmt.Sprintf("%s/users/%s.json", host, url.PathEscape(creds.username))
+		req, err := http.NewRequest("GET", endpoint, nil)
+		if err != nil {
+			return loginResultMsg{creds: creds, err: err}
+		}
+		req.Header.Set("User-Agent", userAgent)
+
+		body, err := fetchAPI(context.Background(), authenticatedClient(creds), req)
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			switch statusErr.code {
+			case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+				err = errInvalidLogin
+			default:
+				err = fmt.Errorf("API request failed with %w", statusErr)
+			}
+		}
+		if err != nil {
+			log.Printf("Login failed: %v", err)
+			return loginResultMsg{creds: creds, err: err}
+		}
+
+		var user e621User
+		if err := json.Unmarshal(body, &user); err != nil {
+			log.Printf("Error decoding user: %v", err)
+			return loginResultMsg{creds: creds, err: err}
+		}
+		return loginResultMsg{creds: creds, user: user}
+	}
+}
+
+// restoreLoginCmd checks credentials remembered from an earlier session.
+func restoreLoginCmd(host string, creds credentials) tea.Cmd {
+	validate := validateLoginCmd(host, creds)
+	return func() tea.Msg {
+		msg := validate().(loginResultMsg)
+		msg.restored = true
+		return msg
+	}
+}
+
+// loginForm holds the state of the login dialog on the entrance screen.
+type loginForm struct {
+	active   bool
+	username textinput.Model
+	apiKey   textinput.Model
+	checking bool
+	err      error
+}
+
+func newLoginForm() loginForm {
+	username := textinput.New()
+	username.Placeholder = "Username"
+	username.CharLimit = 64
+	username.Width = 44
+
+	apiKey := textinput.New()
+	apiKey.Placeholder = "API key"
+	apiKey.CharLimit = 64
+	apiKey.Width = 44
+	apiKey.EchoMode = textinput.EchoPassword
+	apiKey.EchoCharacter = '•'
+
+	return loginForm{username: username, apiKey: apiKey}
+}
+
+// openLogin shows the login dialog.
+func (m *model) openLogin() tea.Cmd {
+	m.login = newLoginForm()
+	m.login.active = true
+	return m.login.username.Focus()
+}
+
+// logout forgets the credentials of the session.
+func (m *model) logout() {
+	log.Printf("Logged out of e621 account %s", m.account.Name)
+	m.account = nil
+	m.credentials = nil
+	m.httpClient = upstreamClient
+	m.rememberLogin(nil)
+	// The main menu loses the buttons that need an account.
+	m.selectedButton = min(m.selectedButton, len(m.menuButtons())-1)
+}
+
+// restoreLogin logs in with saved credentials right away. Until
+// restoreLoginCmd confirms them, only the username is known.
+func (m *model) restoreLogin(creds credentials) {
+	m.account = &e621User{Name: creds.username}
+	m.credentials = &creds
+	m.httpClient = authenticatedClient(creds)
+}
+
+// updateLogin handles keys while the login dialog is open.
+func (m *model) updateLogin(msg tea.KeyMsg) tea.Cmd {
+	if m.login.checking {
+		if key.Matches(msg, key.NewBinding(key.WithKeys("esc"))) {
+			m.login.active = false
+		}
+		return nil
+	}
+
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("esc"))):
+		m.login.active = false
+		return nil
+	case key.Matches(msg, key.NewBinding(key.WithKeys("tab", "shift+tab", "up", "down"))):
+		return m.login.toggleFocus()
+	case key.Matches(msg, key.NewBinding(key.WithKeys("enter"))):
+		if m.login.username.Focused() {
+			return m.login.toggleFocus()
+		}
+		creds := credentials{
+			username: strings.TrimSpace(m.login.username.Value()),
+			apiKey:   strings.TrimSpace(m.login.apiKey.Value()),
+		}
+		if creds.username == "" || creds.apiKey == "" {
+			m.login.err = errors.New("enter both your username and API key")
+			return nil
+		}
+		m.login.checking = true
+		m.login.err = nil
+		return validateLoginCmd(m.apiBase(), creds)
+	}
+
+	var cmd tea.Cmd
+	if m.login.username.Focused() {
+		m.login.username, cmd = m.login.username.Update(msg)
+	} else {
+		m.login.apiKey, cmd = m.login.apiKey.Update(msg)
+	}
+	return cmd
+}
+
+// updateInputs passes non-key messages, like cursor blinks, to the inputs.
+func (f *loginForm) updateInputs(msg tea.Msg) tea.Cmd {
+	var usernameCmd, apiKeyCmd tea.Cmd
+	f.username, usernameCmd = f.username.Update(msg)
+	f.apiKey, apiKeyCmd = f.apiKey.Update(msg)
+	return tea.Batch(usernameCmd, apiKeyCmd)
+}
+
+func (f *loginForm) toggleFocus() tea.Cmd {
+	if f.username.Focused() {
+		f.username.Blur()
+		return f.apiKey.Focus()
+	}
+	f.apiKey.Blur()
+	return f.username.Focus()
+}
+
+// finishLogin applies the result of validateLoginCmd or restoreLoginCmd.
+func (m *model) finishLogin(msg loginResultMsg) {
+	if msg.restored {
+		if m.credentials == nil || *m.credentials != msg.creds {
+			// The user logged out or in again in the meantime.
+			return
+		}
+		switch {
+		case errors.Is(msg.err, errInvalidLogin):
+			log.Printf("Saved e621 login for %s no longer works", msg.creds.username)
+			m.logout()
+		case msg.err == nil:
+			user := msg.user
+			m.account = &user
+			m.applyAccountBlacklist(user)
+		}
+		return
+	}
+
+	if !m.login.active || !m.login.checking {
+		// The dialog was closed while we were waiting.
+		return
+	}
+	m.login.checking = false
+	if msg.err != nil {
+		m.login.err = msg.err
+		return
+	}
+	user := msg.user
+	creds := msg.creds
+	m.account = &user
+	m.credentials = &creds
+	m.httpClient = authenticatedClient(creds)
+	m.login.active = false
+	m.rememberLogin(&creds)
+	m.applyAccountBlacklist(user)
+	log.Printf("Logged in to e621 as %s", user.Name)
+}
+
+// loginView renders the login dialog.
+func (m *model) loginView() string {
+	inputStyle := func(focused bool) lipgloss.Style {
+		if focused {
+			return searchBoxStyle.Copy().BorderForeground(highlight)
+		}
+		return searchBoxStyle
+	}
+
+	var status string
+	switch {
+	case m.login.checking:
+		status = helpStyle.Render("Checking credentials...")
+	case m.login.err != nil:
+		status = lipgloss.NewStyle().Foreground(errorColor).Render(m.login.err.Error())
+	default:
+		status = helpStyle.Render("Find your API key under Account > Manage API Access on e621.")
+	}
+
+	return lipgloss.JoinVertical(
+		lipgloss.Center,
+		"Log in to e621",
+		inputStyle(m.login.username.Focused()).Render(m.login.username.View()),
+		inputStyle(m.login.apiKey.Focused()).Render(m.login.apiKey.View()),
+		status,
+		"",
+		helpStyle.Render("tab: next field | enter: log in | esc: cancel"),
+	)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- Tag Autocomplete ---
+
+const (
+	// autocompleteDelay is how long typing has to pause before we ask e621.
+	autocompleteDelay = 250 * time.Millisecond
+	// autocompleteMinLength is the shortest prefix e621 completes.
+	autocompleteMinLength = 3
+	maxSuggestions        = 8
+)
+
+// tagCategoryColors are the colors e621 uses for its tag categories.
+var tagCategoryColors = map[int]lipgloss.Color{
+	0: lipgloss.Color("#b4c7d9"), // general
+	1: lipgloss.Color("#f2ac08"), // artist
+	3: lipgloss.Color("#dd00dd"), // copyright
+	4: lipgloss.Color("#00aa00"), // character
+	5: lipgloss.Color("#ed5d1f"), // species
+	6: lipgloss.Color("#ff3d3d"), // invalid
+	7: lipgloss.Color("#ffffff"), // meta
+	8: lipgloss.Color("#228822"), // lore
+}
+
+var autocompleteStyle = lipgloss.NewStyle().
+	Border(lipgloss.RoundedBorder()).
+	BorderForeground(subtle).
+	Padding(0, 1)
+
+type tagSuggestion struct {
+	Name           string `json:"name"`
+	PostCount      int    `json:"post_count"`
+	Category       int    `json:"category"`
+	AntecedentName string `json:"antecedent_name"`
+}
+
+// autocomplete holds the suggestions for the tag at the search box's cursor.
+type autocomplete struct {
+	seq         int    // Identifies the latest lookup, older results are dropped.
+	term        string // What the suggestions are for.
+	suggestions []tagSuggestion
+	selected    int
+}
+
+type autocompleteDueMsg struct {
+	seq  int
+	term string
+}
+
+type autocompleteResultMsg struct {
+	seq  int
+	tags []tagSuggestion
+}
+
+// autocompleteCmd looks up tags starting with term. Results are cached like
+// any other API response.
+func autocompleteCmd(client *http.Client, host, term string, seq int) tea.Cmd {
+	return func() tea.Msg {
+		q := url.Values{"search[name_matches]": {term}, "expiry": {"7"}}
+		req, err := http.NewRequest("GET", host+"/tags/autocomplete.json?"+q.Encode(), nil)
+		if err != nil {
+			return nil
+		}
+		req.Header.Set("User-Agent", userAgent)
+
+		body, err := upstreamCache.get(context.Background(), "tags:"+req.URL.String(), cacheTags, func(ctx context.Context) ([]byte, error) {
+			return fetchAPI(ctx, client, req)
+		})
+		if err != nil {
+			log.Printf("Failed to autocomplete %q: %v", term, err)
+			return nil
+		}
+		var tags []tagSuggestion
+		if err := json.Unmarshal(body, &tags); err != nil {
+			// e621 answers with an empty object instead of a list when nothing
+			// matches.
+			return autocompleteResultMsg{seq: seq}
+		}
+		return autocompleteResultMsg{seq: seq, tags: tags}
+	}
+}
+
+// currentToken returns the byte offsets of the search term at the cursor.
+func (m *model) currentToken() (start, end int) {
+	val := m.searchBox.Value()
+	runes := []rune(val)
+	pos := len(string(runes[:min(m.searchBox.Position(), len(runes))]))
+	start = strings.LastIndex(val[:pos], " ") + 1
+	end = len(val)
+	if i := strings.Index(val[pos:], " "); i >= 0 {
+		end = pos + i
+	}
+	return start, end
+}
+
+// completableTerm returns the tag at the cursor without its - or ~ prefix, or
+// "" if there's nothing to complete.
+func (m *model) completableTerm() string {
+	start, end := m.currentToken()
+	term := strings.TrimLeft(m.searchBox.Value()[start:end], "-~")
+	if len(term) < autocompleteMinLength || strings.Contains(term, ":") {
+		return ""
+	}
+	return strings.ToLower(term)
+}
+
+// scheduleAutocomplete looks up the term at the cursor once the user stops
+// typing.
+func (m *model) scheduleAutocomplete() tea.Cmd {
+	term := ""
+	if m.searchBox.Focused() {
+		term = m.completableTerm()
+	}
+	if term == m.autocomplete.term {
+		return nil
+	}
+	m.autocomplete.seq++
+	m.autocomplete.term = term
+	m.autocomplete.suggestions = nil
+	if term == "" {
+		return nil
+	}
+	seq := m.autocomplete.seq
+	return tea.Tick(autocompleteDelay, func(time.Time) tea.Msg {
+		return autocompleteDueMsg{seq: seq, term: term}
+	})
+}
+
+// clearAutocomplete hides the suggestions.
+func (m *model) clearAutocomplete() {
+	m.autocomplete = autocomplete{seq: m.autocomplete.seq + 1}
+}
+
+// suppressAutocomplete hides the suggestions and doesn't look up the term at
+// the cursor again, for when the query was filled in rather than typed.
+func (m *model) suppressAutocomplete() {
+	m.clearAutocomplete()
+	m.autocomplete.term = m.completableTerm()
+}
+
+// updateAutocomplete handles the autocomplete messages on any screen. It
+// reports whether msg was one of them.
+func (m *model) updateAutocomplete(msg tea.Msg) (tea.Cmd, bool) {
+	switch msg := msg.(type) {
+	case autocompleteDueMsg:
+		if msg.seq != m.autocomplete.seq {
+			return nil, true
+		}
+		return autocompleteCmd(m.httpClient, m.apiBase(), msg.term, msg.seq), true
+	case autocompleteResultMsg:
+		if msg.seq == m.autocomplete.seq && m.searchBox.Focused() {
+			m.autocomplete.suggestions = msg.tags[:min(len(msg.tags), maxSuggestions)]
+			m.autocomplete.selected = 0
+		}
+		return nil, true
+	}
+	return nil, false
+}
+
+// autocompleteKey handles keys while suggestions are shown: up/down pick one,
+// tab inserts it and esc hides them. It reports whether the key was used.
+func (m *model) autocompleteKey(msg tea.KeyMsg) bool {
+	n := len(m.autocomplete.suggestions)
+	if n == 0 {
+		return false
+	}
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("up", "ctrl+p"))):
+		m.autocomplete.selected = (m.autocomplete.selected + n - 1) % n
+	case key.Matches(msg, key.NewBinding(key.WithKeys("down", "ctrl+n"))):
+		m.autocomplete.selected = (m.autocomplete.selected + 1) % n
+	case key.Matches(msg, key.NewBinding(key.WithKeys("tab"))):
+		m.acceptSuggestion(m.autocomplete.suggestions[m.autocomplete.selected])
+	case key.Matches(msg, key.NewBinding(key.WithKeys("esc"))):
+		m.clearAutocomplete()
+	default:
+		return false
+	}
+	return true
+}
+
+// acceptSuggestion replaces the term at the cursor with the suggested tag,
+// keeping its - or ~ prefix.
+func (m *model) acceptSuggestion(tag tagSuggestion) {
+	val := m.searchBox.Value()
+	start, end := m.currentToken()
+	token := val[start:end]
+	prefix := token[:len(token)-len(strings.TrimLeft(token, "-~"))]
+
+	rest := strings.TrimLeft(val[end:], " ")
+	newVal := val[:start] + prefix + tag.Name + " "
+	cursor := utf8.RuneCountInString(newVal)
+	m.searchBox.SetValue(newVal + rest)
+	m.searchBox.SetCursor(cursor)
+	m.suppressAutocomplete()
+}
+
+// autocompleteView renders the suggestions, or "" if there are none.
+func (m *model) autocompleteView(width int) string {
+	if len(m.autocomplete.suggestions) == 0 || !m.searchBox.Focused() {
+		return ""
+	}
+	inner := width - 4
+	var lines []string
+	for i, tag := range m.autocomplete.suggestions {
+		name := tag.Name
+		if tag.AntecedentName != "" {
+			name = tag.AntecedentName + " → " + tag.Name
+		}
+		count := formatCount(tag.PostCount)
+		name = truncate(sanitizeText(name), inner-len(count)-3)
+
+		marker := "  "
+		if i == m.autocomplete.selected {
+			marker = "› "
+		}
+		color, ok := tagCategoryColors[tag.Category]
+		if !ok {
+			color = text
+		}
+		nameView := lipgloss.NewStyle().Foreground(color).Render(name)
+		gap := max(inner-lipgloss.Width(marker+name)-len(count), 1)
+		lines = append(lines, marker+nameView+strings.Repeat(" ", gap)+helpStyle.Render(count))
+	}
+	return autocompleteStyle.Width(width - 2).Render(strings.Join(lines, "\n"))
+}
+
+// formatCount shortens large post counts, e.g. 1234 to 1.2k and 12345 to 12k.
+func formatCount(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	case n >= 10_000:
+		return fmt.Sprintf("%dk", n/1000)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	default:
+		return fmt.Sprint(n)
+	}
+}
+
+// truncate shortens s to at most n cells, adding an ellipsis if needed.
+func truncate(s string, n int) string {
+	if lipgloss.Width(s) <= n || n < 1 {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && lipgloss.Width(string(runes))+1 > n {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- Blacklist ---
+//
+// The blacklist uses e621's syntax: a post is blacklisted if it matches any
+// line. Within a line all tags must match, "-tag" must not match and at least
+// one of the "~tag" ones must match. Besides tags, rating:, score:, id:,
+// width: and height: are understood.
+
+// blacklistTerm is a single tag or metatag of a blacklist line.
+type blacklistTerm struct {
+	negated  bool
+	optional bool // Prefixed with ~.
+	match    func(p *Post, tags map[string]bool) bool
+}
+
+type blacklistRule []blacklistTerm
+
+type blacklist []blacklistRule
+
+// parseBlacklist parses a blacklist with one rule per line. Empty lines and
+// lines starting with # are ignored.
+func parseBlacklist(text string) blacklist {
+	var b blacklist
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(strings.ToLower(line))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule blacklistRule
+		for _, token := range strings.Fields(line) {
+			if term, ok := parseBlacklistTerm(token); ok {
+				rule = append(rule, term)
+			}
+		}
+		if len(rule) > 0 {
+			b = append(b, rule)
+		}
+	}
+	return b
+}
+
+func parseBlacklistTerm(token string) (blacklistTerm, bool) {
+	var term blacklistTerm
+	for token != "" {
+		if token[0] == '-' {
+			term.negated = true
+		} else if token[0] == '~' {
+			term.optional = true
+		} else {
+			break
+		}
+		token = token[1:]
+	}
+	if token == "" {
+		return term, false
+	}
+
+	name, value, _ := strings.Cut(token, ":")
+	switch name {
+	case "rating":
+		if value == "" {
+			return term, false
+		}
+		rating := value[:1]
+		term.match = func(p *Post, _ map[string]bool) bool { return p.Rating == rating }
+	case "score", "id", "width", "height":
+		inRange, ok := parseNumericRange(value)
+		if !ok {
+			return term, false
+		}
+		field := name
+		term.match = func(p *Post, _ map[string]bool) bool {
+			switch field {
+			case "score":
+				return inRange(p.Score.Total)
+			case "id":
+				return inRange(p.ID)
+			case "width":
+				return inRange(p.File.Width)
+			default:
+				return inRange(p.File.Height)
+			}
+		}
+	default:
+		tag := token
+		term.match = func(_ *Post, tags map[string]bool) bool { return tags[tag] }
+	}
+	return term, true
+}
+
+// parseNumericRange parses the values of numeric metatags: "5", "<5", "<=5",
+// ">5", ">=5", "1..5", "1.." and "..5".
+func parseNumericRange(value string) (func(int) bool, bool) {
+	parse := func(s string) (int, bool) {
+		n, err := strconv.Atoi(s)
+		return n, err == nil
+	}
+
+	ops := []struct {
+		prefix string
+		cmp    func(a, b int) bool
+	}{
+		{"<=", func(a, b int) bool { return a <= b }},
+		{">=", func(a, b int) bool { return a >= b }},
+		{"<", func(a, b int) bool { return a < b }},
+		{">", func(a, b int) bool { return a > b }},
+	}
+	for _, op := range ops {
+		if rest, ok := strings.CutPrefix(value, op.prefix); ok {
+			n, ok := parse(rest)
+			cmp := op.cmp
+			return func(v int) bool { return cmp(v, n) }, ok
+		}
+	}
+
+	if from, to, ok := strings.Cut(value, ".."); ok {
+		lo, hi := math.MinInt, math.MaxInt
+		okLo, okHi := true, true
+		if from != "" {
+			lo, okLo = parse(from)
+		}
+		if to != "" {
+			hi, okHi = parse(to)
+		}
+		return func(v int) bool { return v >= lo && v <= hi }, okLo && okHi
+	}
+
+	n, ok := parse(value)
+	return func(v int) bool { return v == n }, ok
+}
+
+// matches reports whether the post is blacklisted by any rule.
+func (b blacklist) matches(p Post) bool {
+	if len(b) == 0 {
+		return false
+	}
+	tags := p.tagSet()
+	for _, rule := range b {
+		if rule.matches(&p, tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r blacklistRule) matches(p *Post, tags map[string]bool) bool {
+	hasOptional, anyOptional := false, false
+	for _, term := range r {
+		match := term.match(p, tags) != term.negated
+		if term.optional {
+			hasOptional = true
+			anyOptional = anyOptional || match
+		} else if !match {
+			return false
+		}
+	}
+	return !hasOptional || anyOptional
+}
+
+// tagSet returns the tags of all categories of the post.
+func (p *Post) tagSet() map[string]bool {
+	tags := map[string]bool{}
+	for _, category := range [][]string{
+		p.Tags.General, p.Tags.Species, p.Tags.Character, p.Tags.Copyright,
+		p.Tags.Artist, p.Tags.Invalid, p.Tags.Lore, p.Tags.Meta,
+	} {
+		for _, tag := range category {
+			tags[tag] = true
+		}
+	}
+	return tags
+}
+
+// setBlacklist replaces the blacklist of the session.
+func (m *model) setBlacklist(text string) {
+	m.blacklistText = text
+	m.blacklist = parseBlacklist(text)
+}
+
+// isBlacklisted reports whether the post should be hidden.
+func (m *model) isBlacklisted(p Post) bool {
+	return m.blacklist.matches(p)
+}
+
+// --- Blacklist Editor ---
+
+type blacklistSyncedMsg struct{ err error }
+
+// syncBlacklistCmd saves the blacklist to the user's e621 account.
+func syncBlacklistCmd(client *http.Client, host string, userID int, text string) tea.Cmd {
+	return func() tea.Msg {
+		form := url.Values{"user[blacklisted_tags]": {text}}
+		endpoint := fmt.Sprintf("%s/users/%d.json", host, userID)
+		req, err := http.NewRequest("PATCH", endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return blacklistSyncedMsg{err}
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", userAgent)
+
+		_, err = fetchAPI(context.Background(), client, req)
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			err = fmt.Errorf("API request failed with %w", statusErr)
+		}
+		if err != nil {
+			log.Printf("Failed to save blacklist to e621: %v", err)
+		}
+		return blacklistSyncedMsg{err}
+	}
+}
+
+// openBlacklistEditor shows the blacklist in place of the posts.
+func (m *model) openBlacklistEditor() tea.Cmd {
+	if m.cancelPreview != nil {
+		m.cancelPreview()
+	}
+	m.animation = nil
+	m.previewViewport.SetContent("")
+
+	m.blacklistEditor = textarea.New()
+	m.blacklistEditor.Placeholder = "One rule per line, e.g.\ngore\nrating:e -male\n~spiders ~insects"
+	m.blacklistEditor.ShowLineNumbers = false
+	m.blacklistEditor.CharLimit = 0
+	m.blacklistEditor.SetValue(m.blacklistText)
+	m.editingBlacklist = true
+	return tea.Batch(tea.ClearScreen, m.blacklistEditor.Focus())
+}
+
+// updateBlacklistEditor handles keys while the editor is open.
+func (m *model) updateBlacklistEditor(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("esc"))):
+		return m.closeBlacklistEditor()
+	case key.Matches(msg, key.NewBinding(key.WithKeys("ctrl+s"))):
+		return m.saveBlacklist(m.blacklistEditor.Value())
+	}
+	var cmd tea.Cmd
+	m.blacklistEditor, cmd = m.blacklistEditor.Update(msg)
+	return cmd
+}
+
+// saveBlacklist applies the edited blacklist, remembers it and, when logged
+// in, saves it to the e621 account too.
+func (m *model) saveBlacklist(text string) tea.Cmd {
+	m.setBlacklist(text)
+	m.settings.Blacklist = text
+	m.saveSettings()
+
+	cmds := []tea.Cmd{m.closeBlacklistEditor()}
+	m.statusMessage = fmt.Sprintf("Blacklist saved, %d rules", len(m.blacklist))
+	if m.account != nil && m.account.ID != 0 {
+		m.statusMessage += ", syncing with e621..."
+		cmds = append(cmds, syncBlacklistCmd(m.httpClient, m.apiBase(), m.account.ID, text))
+	} else {
+		cmds = append(cmds, clearStatusCmd(2*time.Second))
+	}
+	return tea.Batch(cmds...)
+}
+
+// closeBlacklistEditor goes back to the posts, reapplying the blacklist.
+func (m *model) closeBlacklistEditor() tea.Cmd {
+	m.editingBlacklist = false
+	m.blacklistEditor.Blur()
+	m.updateTableRows()
+	if len(m.posts) == 0 {
+		return tea.ClearScreen
+	}
+	if m.gridMode {
+		m.renderGridTiles()
+		return tea.Batch(tea.ClearScreen, m.fetchVisibleThumbnailsCmd())
+	}
+	return m.triggerPreviewUpdate()
+}
+
+// applyAccountBlacklist uses the blacklist of the user's e621 account, if
+// they have one.
+func (m *model) applyAccountBlacklist(user e621User) {
+	if user.BlacklistedTags == "" || user.BlacklistedTags == m.blacklistText {
+		return
+	}
+	m.setBlacklist(user.BlacklistedTags)
+	m.settings.Blacklist = user.BlacklistedTags
+	m.saveSettings()
+	if len(m.posts) > 0 {
+		m.updateTableRows()
+	}
+}
+
+// blacklistView renders the editor.
+func (m *model) blacklistView(height int) string {
+	m.blacklistEditor.SetWidth(m.width - 4)
+	m.blacklistEditor.SetHeight(height - 6)
+
+	help := "Posts matching any line are hidden. Within a line, all tags must match, " +
+		"-tag must not and at least one ~tag must. rating:, score:, id:, width: and height: work too."
+	if m.account != nil {
+		help += " Saving also updates the blacklist of your e621 account."
+	}
+
+	view := lipgloss.JoinVertical(
+		lipgloss.Left,
+		"Blacklist",
+		lipgloss.NewStyle().Width(m.width-4).Render(helpStyle.Render(help)),
+		"",
+		m.blacklistEditor.View(),
+	)
+	view = paneStyle.Width(m.width).Height(height).Render(view)
+	if m.imageProtocol == protocolKitty {
+		view = kittyDeleteAll() + view
+	}
+	return view
+}
@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func testPost(id int, rating string, score int, tags ...string) Post {
+	var p Post
+	p.ID = id
+	p.Rating = rating
+	p.Score.Total = score
+	p.Tags.General = tags
+	p.File.Width, p.File.Height = 1920, 1080
+	return p
+}
+
+func TestBlacklistMatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		blacklist string
+		post      Post
+		want      bool
+	}{
+		{"empty", "", testPost(1, "s", 0, "gore"), false},
+		{"tag", "gore", testPost(1, "s", 0, "gore"), true},
+		{"other tag", "gore", testPost(1, "s", 0, "cat"), false},
+		{"case insensitive", "Gore", testPost(1, "s", 0, "gore"), true},
+		{"all tags must match", "gore cat", testPost(1, "s", 0, "gore"), false},
+		{"all tags match", "gore cat", testPost(1, "s", 0, "gore", "cat"), true},
+		{"any line", "dog\ngore", testPost(1, "s", 0, "gore"), true},
+		{"comment", "# gore", testPost(1, "s", 0, "gore"), false},
+
+		{"negated tag absent", "gore -cat", testPost(1, "s", 0, "gore"), true},
+		{"negated tag present", "gore -cat", testPost(1, "s", 0, "gore", "cat"), false},
+		{"only negated", "-cat", testPost(1, "s", 0, "dog"), true},
+
+		{"optional one matches", "~spiders ~insects", testPost(1, "s", 0, "insects"), true},
+		{"optional none match", "~spiders ~insects", testPost(1, "s", 0, "cat"), false},
+		{"optional with required", "gore ~spiders ~insects", testPost(1, "s", 0, "spiders"), false},
+		{"optional and required", "gore ~spiders ~insects", testPost(1, "s", 0, "gore", "spiders"), true},
+		{"negated optional", "~-cat ~dog", testPost(1, "s", 0, "cat"), false},
+
+		{"rating", "rating:e", testPost(1, "e", 0), true},
+		{"other rating", "rating:e", testPost(1, "s", 0), false},
+		{"rating long form", "rating:explicit", testPost(1, "e", 0), true},
+		{"rating with tag", "rating:e -male", testPost(1, "e", 0, "male"), false},
+		{"negated rating", "-rating:s", testPost(1, "q", 0), true},
+		{"rating without value", "rating:", testPost(1, "s", 0), false},
+
+		{"score below zero", "score:<0", testPost(1, "s", -3), true},
+		{"score zero", "score:<0", testPost(1, "s", 0), false},
+		{"score at most", "score:<=0", testPost(1, "s", 0), true},
+		{"score range", "score:10..20", testPost(1, "s", 15), true},
+		{"score open range", "score:..-10", testPost(1, "s", -5), false},
+		{"id", "id:5", testPost(5, "s", 0), true},
+		{"width", "width:>1000", testPost(1, "s", 0), true},
+		{"height", "height:>=2000", testPost(1, "s", 0), false},
+		{"invalid number", "score:<abc", testPost(1, "s", -3), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseBlacklist(tt.blacklist).matches(tt.post); got != tt.want {
+				t.Errorf("blacklist %q matches = %v, want %v", tt.blacklist, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBlacklist(t *testing.T) {
+	b := parseBlacklist("gore\n\n  # comment\n-cat ~dog score:<0\nscore:abc\n-\n")
+	if len(b) != 2 {
+		t.Fatalf("got %d rules, want 2", len(b))
+	}
+	rule := b[1]
+	if len(rule) != 3 {
+		t.Fatalf("got %d terms in %q, want 3", len(rule), "-cat ~dog score:<0")
+	}
+	if !rule[0].negated || rule[0].optional {
+		t.Errorf("-cat: negated = %v, optional = %v", rule[0].negated, rule[0].optional)
+	}
+	if rule[1].negated || !rule[1].optional {
+		t.Errorf("~dog: negated = %v, optional = %v", rule[1].negated, rule[1].optional)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// --- Text Renderers ---
+//
+// These draw images with plain characters and SGR colours, so they work in
+// any terminal (and inside tmux) at the cost of resolution.
+
+// textRenderMode selects how an image is turned into characters.
+type textRenderMode int
+
+const (
+	// textHalfBlocks draws two pixels per cell using ▀ with 24-bit colours.
+	textHalfBlocks textRenderMode = iota
+	// textHalfBlocks256 is textHalfBlocks limited to the xterm 256 colours.
+	textHalfBlocks256
+	// textBraille draws 2x4 dots per cell using braille patterns.
+	textBraille
+)
+
+// brailleContrast is the luminance range below which a braille cell is drawn
+// fully lit instead of thresholded, so flat areas don't disappear.
+const brailleContrast = 24
+
+// brailleBits maps a dot at (x, y) within a 2x4 cell to its bit.
+var brailleBits = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// encodeText renders img as lines of coloured characters, centred within a
+// box of cols x rows cells. The result can be handed straight to a viewport.
+func encodeText(img image.Image, mode textRenderMode, cols, rows int, cell cellSize) (string, error) {
+	b := img.Bounds()
+	fit := fitImage(b.Dx(), b.Dy(), placement{cols: cols, rows: rows}, cell)
+	if fit.cols == 0 || fit.rows == 0 {
+		return "", fmt.Errorf("no room to draw image in %dx%d cells", cols, rows)
+	}
+
+	var lines []string
+	switch mode {
+	case textBraille:
+		lines = brailleLines(flattenImage(scaleImage(img, fit.cols*2, fit.rows*4)), fit.cols, fit.rows)
+	default:
+		lines = halfBlockLines(flattenImage(scaleImage(img, fit.cols, fit.rows*2)), fit.cols, fit.rows, mode == textHalfBlocks256)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(strings.Repeat("\n", fit.y))
+	padding := strings.Repeat(" ", fit.x)
+	for i, line := range lines {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(padding)
+		sb.WriteString(line)
+	}
+	return sb.String(), nil
+}
+
+// halfBlockLines draws each pair of pixel rows as one line of ▀ characters,
+// with the top pixel as foreground and the bottom one as background.
+func halfBlockLines(img *image.RGBA, cols, rows int, use256 bool) []string {
+	lines := make([]string, 0, rows)
+	for row := 0; row < rows; row++ {
+		var sb strings.Builder
+		for col := 0; col < cols; col++ {
+			top := rgbaAt(img, col, row*2)
+			bottom := rgbaAt(img, col, row*2+1)
+			if use256 {
+				fmt.Fprintf(&sb, "\x1b[38;5;%d;48;5;%dm▀", xterm256(top), xterm256(bottom))
+			} else {
+				fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%d;48;2;%d;%d;%dm▀", top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+			}
+		}
+		sb.WriteString("\x1b[0m")
+		lines = append(lines, sb.String())
+	}
+	return lines
+}
+
+// brailleLines draws each 2x4 block of pixels as a braille character. Dots
+// brighter than the cell's average are lit, coloured with their mean colour.
+func brailleLines(img *image.RGBA, cols, rows int) []string {
+	lines := make([]string, 0, rows)
+	for row := 0; row < rows; row++ {
+		var sb strings.Builder
+		for col := 0; col < cols; col++ {
+			var lum [4][2]int
+			lo, hi, sum := 255, 0, 0
+			for dy := 0; dy < 4; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					c := rgbaAt(img, col*2+dx, row*4+dy)
+					l := luminance(c)
+					lum[dy][dx] = l
+					lo, hi, sum = min(lo, l), max(hi, l), sum+l
+				}
+			}
+			mean := sum / 8
+
+			var pattern rune
+			var r, g, b, n int
+			for dy := 0; dy < 4; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					if hi-lo >= brailleContrast && lum[dy][dx] <= mean {
+						continue
+					}
+					c := rgbaAt(img, col*2+dx, row*4+dy)
+					pattern |= brailleBits[dy][dx]
+					r, g, b, n = r+int(c.R), g+int(c.G), b+int(c.B), n+1
+				}
+			}
+			fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%dm%c", r/n, g/n, b/n, 0x2800+pattern)
+		}
+		sb.WriteString("\x1b[0m")
+		lines = append(lines, sb.String())
+	}
+	return lines
+}
+
+// flattenImage composites img over the app background, since character
+// cells can't be transparent.
+func flattenImage(img *image.RGBA) *image.RGBA {
+	bg := backgroundRGB()
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		a := uint32(img.Pix[i+3])
+		if a == 0xff {
+			continue
+		}
+		img.Pix[i] = uint8((uint32(img.Pix[i])*a + uint32(bg.R)*(0xff-a)) / 0xff)
+		img.Pix[i+1] = uint8((uint32(img.Pix[i+1])*a + uint32(bg.G)*(0xff-a)) / 0xff)
+		img.Pix[i+2] = uint8((uint32(img.Pix[i+2])*a + uint32(bg.B)*(0xff-a)) / 0xff)
+		img.Pix[i+3] = 0xff
+	}
+	return img
+}
+
+// backgroundRGB returns the app's background colour.
+func backgroundRGB() color.RGBA {
+	var c color.RGBA
+	fmt.Sscanf(string(background), "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	c.A = 0xff
+	return c
+}
+
+func rgbaAt(img *image.RGBA, x, y int) color.RGBA {
+	o := y*img.Stride + x*4
+	return color.RGBA{img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3]}
+}
+
+// luminance returns the perceived brightness of c in the range 0-255.
+func luminance(c color.RGBA) int {
+	return (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+}
+
+// xterm256 returns the closest colour in the xterm 256 colour palette,
+// looking at both the 6x6x6 cube and the grayscale ramp.
+func xterm256(c color.RGBA) int {
+	cubeLevel := func(v uint8) int {
+		if v < 48 {
+			return 0
+		}
+		if v < 115 {
+			return 1
+		}
+		return (int(v) - 35) / 40
+	}
+	levels := [6]int{0, 95, 135, 175, 215, 255}
+	r, g, b := cubeLevel(c.R), cubeLevel(c.G), cubeLevel(c.B)
+	cube := 16 + 36*r + 6*g + b
+	cubeDist := colorDist(c, levels[r], levels[g], levels[b])
+
+	avg := (int(c.R) + int(c.G) + int(c.B)) / 3
+	grayIdx := 23
+	if avg < 238 {
+		grayIdx = max((avg-8)/10, 0)
+	}
+	gray := 8 + 10*grayIdx
+	if colorDist(c, gray, gray, gray) < cubeDist {
+		return 232 + grayIdx
+	}
+	return cube
+}
+
+func colorDist(c color.RGBA, r, g, b int) int {
+	dr, dg, db := int(c.R)-r, int(c.G)-g, int(c.B)-b
+	return dr*dr + dg*dg + db*db
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// --- LRU Cache ---
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+	size  int
+}
+
+// lruCache is a least recently used cache bounded by the total size of its
+// values. It's safe for concurrent use, as it's filled from commands.
+type lruCache[V any] struct {
+	mu       sync.Mutex
+	capacity int
+	size     int
+	sizeOf   func(V) int
+	order    *list.List // Front is most recently used.
+	items    map[string]*list.Element
+}
+
+func newLRUCache[V any](capacity int, sizeOf func(V) int) *lruCache[V] {
+	return &lruCache[V]{
+		capacity: capacity,
+		sizeOf:   sizeOf,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// get returns the value for key and marks it as recently used.
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruEntry[V]).value, true
+}
+
+// contains reports whether key is cached without touching its recency.
+func (c *lruCache[V]) contains(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.items[key]
+	return ok
+}
+
+// put adds a value, evicting the least recently used ones to make room.
+// Values larger than the whole cache aren't stored.
+func (c *lruCache[V]) put(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size := c.sizeOf(value)
+	if size > c.capacity {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.size -= el.Value.(*lruEntry[V]).size
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+	for c.size+size > c.capacity && c.order.Len() > 0 {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*lruEntry[V])
+		c.size -= entry.size
+		c.order.Remove(oldest)
+		delete(c.items, entry.key)
+	}
+	c.items[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value, size: size})
+	c.size += size
+}
+
+// remove drops key from the cache.
+func (c *lruCache[V]) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.size -= el.Value.(*lruEntry[V]).size
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+// --- Preview Cache ---
+
+const (
+	// previewCacheSize bounds the memory used by a session's preview cache.
+	previewCacheSize = 32 << 20
+	// prefetchDistance is how many posts before and after the selected one
+	// are rendered in the background.
+	prefetchDistance = 2
+)
+
+// renderedPreview is a preview ready to be put in the viewport, either a
+// still image or an animation.
+type renderedPreview struct {
+	content string
+	anim    *renderedAnimation
+}
+
+// size returns the approximate memory used by the preview in bytes.
+func (p *renderedPreview) size() int {
+	n := len(p.content)
+	if p.anim != nil {
+		n += len(p.anim.setup)
+		for _, f := range p.anim.frames {
+			n += len(f)
+		}
+	}
+	return n
+}
+
+// previewCacheKey identifies a preview by its URL and everything that affects
+// how it's encoded.
+func previewCacheKey(url string, proto imageProtocol, box placement, cell cellSize) string {
+	return fmt.Sprintf("%s|%s|%dx%d+%d+%d|%dx%d", url, proto, box.cols, box.rows, box.x, box.y, cell.w, cell.h)
+}
+
+// previewCache holds a session's rendered previews, and keeps track of the
+// ones being prefetched so they aren't rendered twice.
+type previewCache struct {
+	*lruCache[*renderedPreview]
+
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+func newPreviewCache(capacity int) *previewCache {
+	return &previewCache{
+		lruCache: newLRUCache(capacity, (*renderedPreview).size),
+		pending:  map[string]bool{},
+	}
+}
+
+// claim marks key as being rendered by a prefetch. It returns false if the
+// preview is already cached or someone else is rendering it.
+func (c *previewCache) claim(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.contains(key) || c.pending[key] {
+		return false
+	}
+	c.pending[key] = true
+	return true
+}
+
+// release undoes claim once rendering finished, successfully or not.
+func (c *previewCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, key)
+}
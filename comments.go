@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- Comments ---
+//
+// The comments of the selected post are shown in place of the preview and
+// post list, newest first. Logged in users can reply to the post.
+
+const (
+	commentsPerPage = 25
+	replyHeight     = 5 // Lines of the reply box.
+)
+
+type comment struct {
+	ID          int       `json:"id"`
+	Body        string    `json:"body"`
+	CreatorID   int       `json:"creator_id"`
+	CreatorName string    `json:"creator_name"`
+	Score       int       `json:"score"`
+	CreatedAt   time.Time `json:"created_at"`
+	IsHidden    bool      `json:"is_hidden"`
+	IsSticky    bool      `json:"is_sticky"`
+}
+
+// commentsView is the state of the comments reader.
+type commentsView struct {
+	active   bool
+	postID   int
+	page     int
+	comments []comment
+	loading  bool
+	err      error
+	scroll   int
+	text     dtextState // Links and spoilers of all comments on the page.
+	replying bool
+	reply    textarea.Model
+	sending  bool
+}
+
+type commentsFetchedMsg struct {
+	postID   int
+	page     int
+	comments []comment
+	err      error
+}
+
+type commentPostedMsg struct {
+	postID int
+	err    error
+}
+
+// fetchCommentsCmd loads a page of the comments of a post.
+func fetchCommentsCmd(client *http.Client, host string, postID, page int) tea.Cmd {
+	return func() tea.Msg {
+		q := url.Values{
+			"group_by":        {"comment"},
+			"search[post_id]": {strconv.Itoa(postID)},
+			"search[order]":   {"id_desc"},
+			"page":            {strconv.Itoa(page)},
+			"limit":           {strconv.Itoa(commentsPerPage)},
+		}
+		req, err := http.NewRequest("GET", host+"/comments.json?"+q.Encode(), nil)
+		if err != nil {
+			return commentsFetchedMsg{postID: postID, page: page, err: err}
+		}
+		req.Header.Set("User-Agent", userAgent)
+
+		body, err := fetchAPI(context.Background(), client, req)
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			err = fmt.Errorf("API request failed with %w", statusErr)
+		}
+		if err != nil {
+			log.Printf("Failed to fetch comments of post %d: %v", postID, err)
+			return commentsFetchedMsg{postID: postID, page: page, err: err}
+		}
+
+		var comments []comment
+		if err := json.Unmarshal(body, &comments); err != nil {
+			// e621 answers with {"comments": []} instead of a list when there
+			// are none.
+			return commentsFetchedMsg{postID: postID, page: page}
+		}
+		return commentsFetchedMsg{postID: postID, page: page, comments: comments}
+	}
+}
+
+// postCommentCmd replies to a post.
+func postCommentCmd(client *http.Client, host string, postID int, body string) tea.Cmd {
+	return func() tea.Msg {
+		form := url.Values{
+			"comment[post_id]": {strconv.Itoa(postID)},
+			"comment[body]":    {body},
+		}
+		req, err := http.NewRequest("POST", host+"/comments.json", strings.NewReader(form.Encode()))
+		if err != nil {
+			return commentPostedMsg{postID: postID, err: err}
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", userAgent)
+
+		_, err = fetchAPI(context.Background(), client, req)
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			err = fmt.Errorf("API request failed with %w", statusErr)
+		}
+		if err != nil {
+			log.Printf("Failed to comment on post %d: %v", postID, err)
+		}
+		return commentPostedMsg{postID: postID, err: err}
+	}
+}
+
+// openComments shows the comments of the selected post.
+func (m *model) openComments() tea.Cmd {
+	if m.loading || len(m.posts) == 0 || m.postTable.Cursor() >= len(m.posts) {
+		return nil
+	}
+	if m.cancelPreview != nil {
+		m.cancelPreview()
+	}
+	m.animation = nil
+	m.previewViewport.SetContent("")
+
+	m.comments = commentsView{active: true, postID: m.posts[m.postTable.Cursor()].ID}
+	return tea.Batch(tea.ClearScreen, m.loadComments(1))
+}
+
+func (m *model) loadComments(page int) tea.Cmd {
+	m.comments.page = page
+	m.comments.loading = true
+	m.comments.err = nil
+	return tea.Batch(fetchCommentsCmd(m.httpClient, m.apiBase(), m.comments.postID, page), m.spinner.Tick)
+}
+
+// closeComments goes back to the posts.
+func (m *model) closeComments() tea.Cmd {
+	m.comments = commentsView{}
+	if m.gridMode {
+		m.renderGridTiles()
+		return tea.ClearScreen
+	}
+	if len(m.posts) == 0 {
+		return tea.ClearScreen
+	}
+	return m.triggerPreviewUpdate()
+}
+
+// applyComments shows a page of comments once it's loaded.
+func (m *model) applyComments(msg commentsFetchedMsg) {
+	if !m.comments.active || msg.postID != m.comments.postID || msg.page != m.comments.page {
+		return
+	}
+	m.comments.loading = false
+	m.comments.err = msg.err
+	m.comments.comments = nil
+	for _, c := range msg.comments {
+		if !c.IsHidden {
+			m.comments.comments = append(m.comments.comments, c)
+		}
+	}
+	m.comments.scroll = 0
+	m.comments.text = newDTextState()
+}
+
+// applyCommentPosted reloads the comments after a reply was sent.
+func (m *model) applyCommentPosted(msg commentPostedMsg) tea.Cmd {
+	m.comments.sending = false
+	if msg.err != nil {
+		m.statusMessage = fmt.Sprintf("Couldn't post your comment: %v", msg.err)
+		return clearStatusCmd(3 * time.Second)
+	}
+	m.statusMessage = "Comment posted"
+	cmds := []tea.Cmd{clearStatusCmd(2 * time.Second)}
+	if m.comments.active && m.comments.postID == msg.postID {
+		m.comments.replying = false
+		m.comments.reply.Reset()
+		cmds = append(cmds, m.loadComments(1))
+	}
+	return tea.Batch(cmds...)
+}
+
+// openReply starts writing a reply to the post.
+func (m *model) openReply() tea.Cmd {
+	if m.account == nil {
+		m.statusMessage = "Log in on the main menu to comment"
+		return clearStatusCmd(2 * time.Second)
+	}
+	if !m.comments.replying {
+		m.comments.reply = textarea.New()
+		m.comments.reply.Placeholder = "Write a comment. DText works here, e.g. [b]bold[/b] or post #123."
+		m.comments.reply.ShowLineNumbers = false
+		m.comments.reply.CharLimit = 10000
+		m.comments.replying = true
+	}
+	return m.comments.reply.Focus()
+}
+
+// updateComments handles keys while the comments are shown.
+func (m *model) updateComments(msg tea.KeyMsg) tea.Cmd {
+	c := &m.comments
+	if c.replying {
+		switch {
+		case key.Matches(msg, key.NewBinding(key.WithKeys("esc"))):
+			c.replying = false
+			c.reply.Blur()
+			return nil
+		case key.Matches(msg, key.NewBinding(key.WithKeys("ctrl+s"))):
+			body := strings.TrimSpace(c.reply.Value())
+			if body == "" || c.sending {
+				return nil
+			}
+			c.sending = true
+			return tea.Batch(postCommentCmd(m.httpClient, m.apiBase(), c.postID, body), m.spinner.Tick)
+		}
+		var cmd tea.Cmd
+		c.reply, cmd = c.reply.Update(msg)
+		return cmd
+	}
+
+	text, links := m.renderComments(m.width - 4)
+	if link, ok := c.text.update(msg, links); ok {
+		if link != nil {
+			return m.followLink(*link)
+		}
+		return nil
+	}
+
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("esc", "q", "C"))):
+		return m.closeComments()
+	case key.Matches(msg, key.NewBinding(key.WithKeys("k", "up"))):
+		c.scroll = max(c.scroll-1, 0)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("j", "down"))):
+		c.scroll++
+	case key.Matches(msg, key.NewBinding(key.WithKeys("pgup"))):
+		c.scroll = max(c.scroll-10, 0)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("pgdown"))):
+		c.scroll += 10
+	case key.Matches(msg, key.NewBinding(key.WithKeys("["))):
+		if c.page > 1 && !c.loading {
+			return m.loadComments(c.page - 1)
+		}
+	case key.Matches(msg, key.NewBinding(key.WithKeys("]"))):
+		if len(c.comments) >= commentsPerPage && !c.loading {
+			return m.loadComments(c.page + 1)
+		}
+	case key.Matches(msg, key.NewBinding(key.WithKeys("r"))):
+		return m.openReply()
+	}
+
+	lines := strings.Count(text, "\n") + 1
+	c.scroll = min(c.scroll, max(lines-m.commentsBodyHeight(m.contentHeight()), 0))
+	return nil
+}
+
+// renderComments renders the comments on the page, returning their links
+// too.
+func (m *model) renderComments(width int) (string, []dtextLink) {
+	c := &m.comments
+	var sections []string
+	var links []dtextLink
+	for _, cm := range c.comments {
+		header := detailTitleStyle.Render(sanitizeText(cm.CreatorName)) +
+			detailLabelStyle.Render(fmt.Sprintf(" · %s · score %+d", cm.CreatedAt.UTC().Format("2006-01-02 15:04 UTC"), cm.Score))
+		if cm.IsSticky {
+			header += detailFlagStyle.Render(" · sticky")
+		}
+
+		// Link numbers run across all comments on the page.
+		state := c.text
+		state.selected -= len(links)
+		body, bodyLinks := renderDText(cm.Body, width, state)
+		links = append(links, bodyLinks...)
+		sections = append(sections, header, body, "")
+	}
+	return strings.Join(sections, "\n"), links
+}
+
+// commentsPaneView renders the comments and, while writing one, the reply.
+func (m *model) commentsPaneView(height int) string {
+	c := &m.comments
+	width := m.width - 4
+
+	title := detailTitleStyle.Render(fmt.Sprintf("Comments on post #%d", c.postID)) +
+		detailLabelStyle.Render(fmt.Sprintf(" · page %d", c.page))
+	var replyView string
+	if c.replying {
+		c.reply.SetWidth(width)
+		c.reply.SetHeight(replyHeight)
+		label := "Your comment"
+		if c.sending {
+			label = m.spinner.View() + " Posting..."
+		}
+		replyView = lipgloss.JoinVertical(lipgloss.Left, "", detailLabelStyle.Render(label), c.reply.View())
+	}
+	bodyHeight := m.commentsBodyHeight(height)
+
+	var body string
+	switch {
+	case c.loading:
+		body = m.spinner.View() + " Loading comments..."
+	case c.err != nil:
+		body = lipgloss.NewStyle().Foreground(errorColor).Render(sanitizeText(fmt.Sprintf("Couldn't load comments: %v", c.err)))
+	case len(c.comments) == 0 && c.page == 1:
+		body = helpStyle.Render("No comments yet.")
+	case len(c.comments) == 0:
+		body = helpStyle.Render("No more comments.")
+	default:
+		text, _ := m.renderComments(width)
+		lines := strings.Split(text, "\n")
+		c.scroll = min(c.scroll, max(len(lines)-bodyHeight, 0))
+		body = strings.Join(lines[c.scroll:min(c.scroll+bodyHeight, len(lines))], "\n")
+	}
+	body = lipgloss.NewStyle().Height(bodyHeight).Render(body)
+
+	view := lipgloss.JoinVertical(lipgloss.Left, title, "", body, replyView)
+	view = paneStyle.Width(m.width).Height(height).Render(view)
+	if m.imageProtocol == protocolKitty {
+		view = kittyDeleteAll() + view
+	}
+	return view
+}
+
+// commentsBodyHeight returns how many lines of comments fit in the pane.
+func (m *model) commentsBodyHeight(height int) int {
+	if m.comments.replying {
+		height -= replyHeight + 2
+	}
+	return max(height-4, 1)
+}
+
+// commentsHelp is the status bar text while the comments are shown.
+func (m *model) commentsHelp() string {
+	if m.comments.replying {
+		return "ctrl+s: post comment | esc: stop writing"
+	}
+	help := "↑/↓: scroll | [/]: page | tab: select link | enter: follow link | s: show spoilers"
+	if m.account != nil {
+		help += " | r: reply"
+	}
+	return help + " | esc: close comments"
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// --- Upstream Configuration ---
+//
+// Where the API and images are fetched from can be changed with a JSON config
+// file, environment variables or flags, in increasing order of precedence.
+// This allows running against mirrors, a mock server or another
+// e621-compatible booru.
+
+var (
+	userAgent      = "e6tea1/v3 t.me/TankKittyCat"
+	apiMirrors     = newMirrorSet("https://e621.net")
+	safeAPIMirrors = newMirrorSet("https://e926.net")
+	// imageBase replaces the scheme, host and path prefix of e621 image URLs
+	// when set.
+	imageBase *url.URL
+)
+
+// upstreamConfig is the format of the config file.
+type upstreamConfig struct {
+	APIHosts     []string `json:"api_hosts"`
+	SafeAPIHosts []string `json:"safe_api_hosts"`
+	ImageHost    string   `json:"image_host"`
+	UserAgent    string   `json:"user_agent"`
+}
+
+// setupConfig loads the upstream configuration from the file given with
+// -config or E6TEA_CONFIG, then E6TEA_API_HOSTS, E6TEA_SAFE_API_HOSTS,
+// E6TEA_IMAGE_HOST and E6TEA_USER_AGENT, then the matching flags.
+func setupConfig(args []string) error {
+	fs := flag.NewFlagSet("e621sh", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("E6TEA_CONFIG"), "JSON config `file`")
+	apiHosts := fs.String("api-hosts", "", "comma separated API `mirrors`, tried in order")
+	safeAPIHosts := fs.String("safe-api-hosts", "", "comma separated API `mirrors` used in safe mode")
+	imageHost := fs.String("image-host", "", "`URL` to fetch images from instead of e621's file servers")
+	agent := fs.String("user-agent", "", "User-Agent `header` sent upstream")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg := upstreamConfig{
+		APIHosts:     apiMirrors.hosts,
+		SafeAPIHosts: safeAPIMirrors.hosts,
+		UserAgent:    userAgent,
+	}
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("invalid config file %s: %w", *configPath, err)
+		}
+	}
+	for _, v := range []string{os.Getenv("E6TEA_API_HOSTS"), *apiHosts} {
+		if v != "" {
+			cfg.APIHosts = strings.Split(v, ",")
+		}
+	}
+	for _, v := range []string{os.Getenv("E6TEA_SAFE_API_HOSTS"), *safeAPIHosts} {
+		if v != "" {
+			cfg.SafeAPIHosts = strings.Split(v, ",")
+		}
+	}
+	for _, v := range []string{os.Getenv("E6TEA_IMAGE_HOST"), *imageHost} {
+		if v != "" {
+			cfg.ImageHost = v
+		}
+	}
+	for _, v := range []string{os.Getenv("E6TEA_USER_AGENT"), *agent} {
+		if v != "" {
+			cfg.UserAgent = v
+		}
+	}
+	return applyConfig(cfg)
+}
+
+// applyConfig validates cfg and makes it the current configuration.
+func applyConfig(cfg upstreamConfig) error {
+	api, err := parseMirrors(cfg.APIHosts)
+	if err != nil {
+		return fmt.Errorf("invalid API hosts: %w", err)
+	}
+	safe, err := parseMirrors(cfg.SafeAPIHosts)
+	if err != nil {
+		return fmt.Errorf("invalid safe mode API hosts: %w", err)
+	}
+	var image *url.URL
+	if cfg.ImageHost != "" {
+		base, err := parseBaseURL(cfg.ImageHost)
+		if err != nil {
+			return fmt.Errorf("invalid image host: %w", err)
+		}
+		image, _ = url.Parse(base)
+	}
+	if strings.TrimSpace(cfg.UserAgent) == "" {
+		return errors.New("the user agent can't be empty")
+	}
+
+	apiMirrors = newMirrorSet(api...)
+	safeAPIMirrors = newMirrorSet(safe...)
+	imageBase = image
+	userAgent = cfg.UserAgent
+	log.Printf("API mirrors: %s, safe mode: %s", strings.Join(api, ", "), strings.Join(safe, ", "))
+	if imageBase != nil {
+		log.Printf("Fetching images from %s", imageBase)
+	}
+	return nil
+}
+
+func parseMirrors(hosts []string) ([]string, error) {
+	var mirrors []string
+	for _, h := range hosts {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		base, err := parseBaseURL(h)
+		if err != nil {
+			return nil, err
+		}
+		mirrors = append(mirrors, base)
+	}
+	if len(mirrors) == 0 {
+		return nil, errors.New("no hosts given")
+	}
+	return mirrors, nil
+}
+
+// parseBaseURL checks that s is an http(s) URL and strips its trailing slash.
+func parseBaseURL(s string) (string, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "/")
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q is not an http(s) URL", s)
+	}
+	return s, nil
+}
+
+// imageURL points an e621 file URL at the configured image host.
+func imageURL(raw string) string {
+	if imageBase == nil {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || !isE621Host(u.Hostname()) {
+		return raw
+	}
+	u.Scheme = imageBase.Scheme
+	u.Host = imageBase.Host
+	u.Path = imageBase.Path + u.Path
+	return u.String()
+}
+
+// --- Mirrors ---
+
+// mirrorSet is a list of interchangeable API hosts. Requests go to the one
+// that worked last, and move on to the next when it's down.
+type mirrorSet struct {
+	hosts   []string
+	current atomic.Int64
+}
+
+func newMirrorSet(hosts ...string) *mirrorSet {
+	return &mirrorSet{hosts: hosts}
+}
+
+// base returns the URL of the mirror requests should go to.
+func (s *mirrorSet) base() string {
+	return s.hosts[s.current.Load()]
+}
+
+// hasHost reports whether hostname belongs to one of the mirrors.
+func (s *mirrorSet) hasHost(hostname string) bool {
+	for _, h := range s.hosts {
+		if u, err := url.Parse(h); err == nil && u.Hostname() == hostname {
+			return true
+		}
+	}
+	return false
+}
+
+// findMirror returns the mirror set and index of the mirror u belongs to.
+func findMirror(u *url.URL) (*mirrorSet, int) {
+	s := u.String()
+	for _, set := range []*mirrorSet{apiMirrors, safeAPIMirrors} {
+		for i, h := range set.hosts {
+			if strings.HasPrefix(s, h+"/") {
+				return set, i
+			}
+		}
+	}
+	return nil, 0
+}
+
+// withMirror returns a copy of req sent to mirror to instead of from.
+func (s *mirrorSet) withMirror(req *http.Request, from, to int) (*http.Request, error) {
+	u, err := url.Parse(s.hosts[to] + strings.TrimPrefix(req.URL.String(), s.hosts[from]))
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.URL = u
+	r.Host = ""
+	if req.GetBody != nil {
+		if r.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// shouldFailOver reports whether err means the mirror is down, rather than
+// the request being wrong or us being rate limited. Requests that change
+// something are only sent again when the first one never left, so a slow
+// mirror can't apply them twice.
+func shouldFailOver(method string, err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if method != "" && method != http.MethodGet && method != http.MethodHead {
+		return notSent(err)
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.code {
+		case http.StatusBadGateway, http.StatusGatewayTimeout, http.StatusInternalServerError:
+			return true
+		case http.StatusServiceUnavailable:
+			return statusErr.retryAfter == 0
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// notSent reports whether err happened before the request could be sent:
+// the mirror's name didn't resolve or it couldn't be connected to.
+func notSent(err error) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	return errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
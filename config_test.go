@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestShouldFailOver(t *testing.T) {
+	dialErr := &url.Error{Op: "Post", URL: "https://e621.net", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	dnsErr := &url.Error{Op: "Post", URL: "https://e621.net", Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Name: "e621.net", Err: "no such host"}}}
+	readErr := &url.Error{Op: "Post", URL: "https://e621.net", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}
+	badGateway := &statusError{code: http.StatusBadGateway}
+
+	tests := []struct {
+		method string
+		err    error
+		want   bool
+	}{
+		{"GET", nil, false},
+		{"GET", context.Canceled, false},
+		{"GET", dialErr, true},
+		{"GET", readErr, true},
+		{"", readErr, true},
+		{"HEAD", badGateway, true},
+		{"GET", &statusError{code: http.StatusNotFound}, false},
+		{"GET", &statusError{code: http.StatusServiceUnavailable}, true},
+		{"GET", &statusError{code: http.StatusServiceUnavailable, retryAfter: 1}, false},
+
+		{"POST", dialErr, true},
+		{"POST", dnsErr, true},
+		{"POST", readErr, false},
+		{"POST", badGateway, false},
+		{"PATCH", fmt.Errorf("wrapped: %w", badGateway), false},
+		{"DELETE", fmt.Errorf("wrapped: %w", dialErr), true},
+	}
+	for _, tt := range tests {
+		if got := shouldFailOver(tt.method, tt.err); got != tt.want {
+			t.Errorf("shouldFailOver(%q, %v) = %v, want %v", tt.method, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFetchAPIFailover(t *testing.T) {
+	var hits [2]atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[0].Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[1].Add(1)
+		fmt.Fprint(w, "{}")
+	}))
+	defer up.Close()
+
+	saved := apiMirrors
+	defer func() { apiMirrors = saved }()
+
+	tests := []struct {
+		method   string
+		wantErr  bool
+		wantHits [2]int32
+	}{
+		{"GET", false, [2]int32{1, 1}},
+		{"POST", true, [2]int32{1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			apiMirrors = newMirrorSet(down.URL, up.URL)
+			hits[0].Store(0)
+			hits[1].Store(0)
+
+			req, err := http.NewRequest(tt.method, down.URL+"/posts.json", strings.NewReader("a=b"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = fetchAPI(context.Background(), http.DefaultClient, req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fetchAPI() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := [2]int32{hits[0].Load(), hits[1].Load()}; got != tt.wantHits {
+				t.Errorf("mirrors were hit %v times, want %v", got, tt.wantHits)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- Post Details ---
+//
+// The details pane shows everything the API tells us about the selected post
+// in place of the post list.
+
+var (
+	detailLabelStyle = lipgloss.NewStyle().Foreground(subtle)
+	detailTitleStyle = lipgloss.NewStyle().Foreground(highlight).Bold(true)
+	detailFlagStyle  = lipgloss.NewStyle().Foreground(errorColor).Bold(true)
+)
+
+var ratingNames = map[string]string{"s": "Safe", "q": "Questionable", "e": "Explicit"}
+
+// updateDetails handles keys while the details pane is open.
+func (m *model) updateDetails(msg tea.KeyMsg) tea.Cmd {
+	if m.postTable.Cursor() < len(m.posts) {
+		width, _ := m.detailsSize()
+		_, links := renderDText(m.posts[m.postTable.Cursor()].Description, width, m.detailsText)
+		if link, ok := m.detailsText.update(msg, links); ok {
+			if link != nil {
+				return m.followLink(*link)
+			}
+			return nil
+		}
+	}
+
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("d", "esc"))):
+		m.showDetails = false
+	case key.Matches(msg, key.NewBinding(key.WithKeys("k", "up"))):
+		m.detailsScroll = max(m.detailsScroll-1, 0)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("j", "down"))):
+		m.detailsScroll++
+	case key.Matches(msg, key.NewBinding(key.WithKeys("pgup"))):
+		m.detailsScroll = max(m.detailsScroll-10, 0)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("pgdown"))):
+		m.detailsScroll += 10
+	}
+
+	if m.postTable.Cursor() < len(m.posts) {
+		width, height := m.detailsSize()
+		lines := strings.Count(postDetails(m.posts[m.postTable.Cursor()], width, m.detailsText), "\n") + 1
+		m.detailsScroll = min(m.detailsScroll, max(lines-height, 0))
+	}
+	return nil
+}
+
+// detailsSize returns the size of the text in the details pane.
+func (m *model) detailsSize() (width, height int) {
+	sidePaneWidth := m.width - m.width*3/4 - 4
+	return sidePaneWidth - 2, m.contentHeight() - 2
+}
+
+// toggleDetails opens or closes the details of the selected post.
+func (m *model) toggleDetails() {
+	if len(m.posts) == 0 {
+		return
+	}
+	m.showDetails = !m.showDetails
+	m.showTags = false
+	m.detailsScroll = 0
+	m.detailsText = newDTextState()
+}
+
+// detailsView renders the details of the selected post, scrolled by
+// m.detailsScroll.
+func (m *model) detailsView(width, height int) string {
+	if m.postTable.Cursor() >= len(m.posts) {
+		return ""
+	}
+	lines := strings.Split(postDetails(m.posts[m.postTable.Cursor()], width, m.detailsText), "\n")
+	scroll := min(m.detailsScroll, max(len(lines)-height, 0))
+	return strings.Join(lines[scroll:min(scroll+height, len(lines))], "\n")
+}
+
+// postDetails formats the metadata of a post to fit in width cells.
+func postDetails(p Post, width int, description dtextState) string {
+	wrap := lipgloss.NewStyle().Width(width)
+	var sections []string
+	field := func(label, value string) {
+		sections = append(sections, wrap.Render(detailLabelStyle.Render(label+": ")+sanitizeText(value)))
+	}
+
+	sections = append(sections, detailTitleStyle.Render(fmt.Sprintf("Post #%d", p.ID)))
+	if flags := postFlags(p); flags != "" {
+		sections = append(sections, wrap.Render(detailFlagStyle.Render(flags)))
+	}
+	sections = append(sections, "")
+
+	rating := ratingNames[p.Rating]
+	if p.Flags.RatingLocked {
+		rating += " (locked)"
+	}
+	field("Rating", rating)
+	field("Score", fmt.Sprintf("%d (▲%d ▼%d)", p.Score.Total, p.Score.Up, -p.Score.Down))
+	field("Favorites", fmt.Sprint(p.FavCount))
+	field("Comments", fmt.Sprint(p.CommentCount))
+	sections = append(sections, "")
+
+	if !p.CreatedAt.IsZero() {
+		field("Uploaded", p.CreatedAt.UTC().Format("2006-01-02 15:04 UTC"))
+	}
+	field("Uploader", fmt.Sprintf("user #%d", p.UploaderID))
+	if p.ApproverID != nil {
+		field("Approver", fmt.Sprintf("user #%d", *p.ApproverID))
+	}
+	if !p.UpdatedAt.IsZero() {
+		field("Updated", p.UpdatedAt.UTC().Format("2006-01-02 15:04 UTC"))
+	}
+	sections = append(sections, "")
+
+	file := fmt.Sprintf("%s, %d×%d, %s", strings.ToUpper(p.File.Ext), p.File.Width, p.File.Height, formatBytes(p.File.Size))
+	field("File", file)
+	if p.Duration != nil {
+		field("Duration", formatDuration(*p.Duration))
+	}
+	if p.File.MD5 != "" {
+		field("MD5", p.File.MD5)
+	}
+
+	if p.Relationships.ParentID != nil || len(p.Relationships.Children) > 0 || len(p.Pools) > 0 {
+		sections = append(sections, "")
+	}
+	if p.Relationships.ParentID != nil {
+		field("Parent", fmt.Sprintf("#%d", *p.Relationships.ParentID))
+	}
+	if len(p.Relationships.Children) > 0 {
+		field("Children", joinIDs(p.Relationships.Children))
+	}
+	if len(p.Pools) > 0 {
+		field("Pools", joinIDs(p.Pools))
+	}
+
+	if len(p.Sources) > 0 {
+		sections = append(sections, "", detailLabelStyle.Render("Sources:"))
+		for _, source := range p.Sources {
+			sections = append(sections, wrap.Render(sanitizeText(source)))
+		}
+	}
+	if len(p.LockedTags) > 0 {
+		sections = append(sections, "")
+		field("Locked tags", strings.Join(p.LockedTags, " "))
+	}
+	if p.Description != "" {
+		text, _ := renderDText(p.Description, width, description)
+		sections = append(sections, "", detailLabelStyle.Render("Description:"), text)
+	}
+	return strings.Join(sections, "\n")
+}
+
+// postFlags describes the moderation state of a post, or "" if there's
+// nothing to say.
+func postFlags(p Post) string {
+	var flags []string
+	if p.Flags.Deleted {
+		flags = append(flags, "Deleted")
+	}
+	if p.Flags.Pending {
+		flags = append(flags, "Pending approval")
+	}
+	if p.Flags.Flagged {
+		flags = append(flags, "Flagged")
+	}
+	if p.Flags.NoteLocked {
+		flags = append(flags, "Notes locked")
+	}
+	if p.Flags.StatusLocked {
+		flags = append(flags, "Status locked")
+	}
+	return strings.Join(flags, " | ")
+}
+
+func joinIDs(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = fmt.Sprintf("#%d", id)
+	}
+	return strings.Join(s, ", ")
+}
+
+// formatBytes formats a file size, e.g. 1.5 MB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatDuration formats a video length in seconds as m:ss.
+func formatDuration(seconds float64) string {
+	s := int(seconds + 0.5)
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
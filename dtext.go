@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- DText ---
+//
+// DText is the markup of e621's descriptions, comments and wiki pages. It's
+// rendered line by line: headings, list items, [quote], [code] and [section]
+// blocks are recognised at the start and end of lines, formatting tags and
+// links anywhere. Links to posts, pools, tags and wiki pages can be selected and
+// followed, and spoilers stay hidden until they're toggled.
+
+type dtextLinkKind int
+
+const (
+	linkURL dtextLinkKind = iota
+	linkPost
+	linkPool
+	linkTag
+)
+
+// dtextLink is a link found while rendering, in the order it appears.
+type dtextLink struct {
+	kind   dtextLinkKind
+	target string // URL, post or pool ID, or tag search.
+}
+
+// dtextState is what the user changed about a rendered text.
+type dtextState struct {
+	showSpoilers bool
+	selected     int // Index of the selected link, -1 for none.
+}
+
+func newDTextState() dtextState {
+	return dtextState{selected: -1}
+}
+
+var (
+	dtextHeadingStyle = lipgloss.NewStyle().Foreground(highlight).Bold(true)
+	dtextQuoteStyle   = lipgloss.NewStyle().Foreground(subtle)
+	dtextCodeStyle    = lipgloss.NewStyle().Foreground(valueColor)
+	dtextLinkStyle    = lipgloss.NewStyle().Foreground(highlight).Underline(true)
+	dtextSpoilerStyle = lipgloss.NewStyle().Foreground(subtle)
+)
+
+var (
+	dtextHeadingRegex = regexp.MustCompile(`^h([1-6])\.\s*(.*)$`)
+	dtextListRegex    = regexp.MustCompile(`^(\*+)\s+(.*)$`)
+	dtextSectionRegex = regexp.MustCompile(`(?i)^\[section(?:,expanded)?(?:=([^\]]*))?\]$`)
+	// dtextInlineRegex matches the inline markup. Only one of its groups is
+	// set for each match.
+	dtextInlineRegex = regexp.MustCompile(`(?i)` +
+		`(\[/?(?:b|i|u|s|spoiler|sup|sub|color(?:=[^\]]*)?)\])` + // 1: formatting
+		`|"([^"]+)":\[([^\]]+)\]` + // 2, 3: "label":[url]
+		`|"([^"]+)":((?:https?://|/)[^\s\[\]<>"]+)` + // 4, 5: "label":url
+		`|\{\{([^}|]+)(?:\|([^}]+))?\}\}` + // 6, 7: {{tag search|label}}
+		`|\[\[([^\]|]+)(?:\|([^\]]+))?\]\]` + // 8, 9: [[wiki page|label]]
+		`|\b(post|pool) #(\d+)\b` + // 10, 11: post #123
+		`|(https?://[^\s\[\]<>"]+)`) // 12: bare URL
+)
+
+// dtextRenderer holds the state that carries across lines, like formatting
+// tags that aren't closed on the same line.
+type dtextRenderer struct {
+	state     dtextState
+	links     []dtextLink
+	bold      bool
+	italic    bool
+	underline bool
+	strike    bool
+	heading   int // Level of the heading being rendered, 0 outside of them.
+	spoiler   int
+}
+
+// renderDText renders DText markup to fit in width cells. It returns the
+// rendered text and the links in it.
+func renderDText(src string, width int, state dtextState) (string, []dtextLink) {
+	r := &dtextRenderer{state: state}
+	width = max(width, 10)
+
+	var out []string
+	inCode := false
+	quoteDepth := 0
+	src = sanitizeText(src)
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		lower := strings.ToLower(trimmed)
+
+		if inCode {
+			if lower == "[/code]" {
+				inCode = false
+				continue
+			}
+			out = append(out, r.block(dtextCodeStyle.Render(line), width, quoteDepth, ""))
+			continue
+		}
+		if lower == "[code]" {
+			inCode = true
+			continue
+		}
+
+		// Quotes open at the start of lines and close at their end.
+		opened, closed := 0, 0
+		for {
+			rest, ok := cutPrefixFold(trimmed, "[quote]")
+			if !ok {
+				break
+			}
+			opened++
+			trimmed = strings.TrimSpace(rest)
+		}
+		for {
+			rest, ok := cutSuffixFold(trimmed, "[/quote]")
+			if !ok {
+				break
+			}
+			closed++
+			trimmed = strings.TrimSpace(rest)
+		}
+		quoteDepth += opened
+		if opened > 0 || closed > 0 {
+			line = trimmed
+		}
+		if trimmed != "" || opened+closed == 0 {
+			if text, ok := r.line(line, width, quoteDepth); ok {
+				out = append(out, text)
+			}
+		}
+		quoteDepth = max(quoteDepth-closed, 0)
+	}
+
+	// Drop the trailing blank lines left by closing tags.
+	for len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == "" {
+		out = out[:len(out)-1]
+	}
+	return strings.Join(out, "\n"), r.links
+}
+
+// line renders a line outside of [code] blocks. It reports false for lines
+// that only close a block.
+func (r *dtextRenderer) line(line string, width, quoteDepth int) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if strings.EqualFold(trimmed, "[/section]") {
+		return "", false
+	}
+	if m := dtextSectionRegex.FindStringSubmatch(trimmed); m != nil {
+		title := m[1]
+		if title == "" {
+			title = "Section"
+		}
+		return r.block(dtextHeadingStyle.Render("▸ "+title), width, quoteDepth, ""), true
+	}
+	if m := dtextHeadingRegex.FindStringSubmatch(trimmed); m != nil {
+		r.heading = int(m[1][0] - '0')
+		defer func() { r.heading = 0 }()
+		return r.block(r.inline(m[2]), width, quoteDepth, ""), true
+	}
+	if m := dtextListRegex.FindStringSubmatch(trimmed); m != nil {
+		indent := strings.Repeat("  ", len(m[1])-1)
+		return r.block(r.inline(m[2]), width, quoteDepth, indent+"• "), true
+	}
+	return r.block(r.inline(line), width, quoteDepth, ""), true
+}
+
+// cutPrefixFold is strings.CutPrefix ignoring case.
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+// cutSuffixFold is strings.CutSuffix ignoring case.
+func cutSuffixFold(s, suffix string) (string, bool) {
+	if len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix) {
+		return s[:len(s)-len(suffix)], true
+	}
+	return s, false
+}
+
+// block wraps a rendered line, prefixing it with quote bars and the bullet
+// of list items.
+func (r *dtextRenderer) block(line string, width, quoteDepth int, bullet string) string {
+	quote := strings.Repeat(dtextQuoteStyle.Render("│ "), quoteDepth)
+	textWidth := max(width-2*quoteDepth-lipgloss.Width(bullet), 1)
+	wrapped := strings.Split(lipgloss.NewStyle().Width(textWidth).Render(line), "\n")
+	hanging := strings.Repeat(" ", lipgloss.Width(bullet))
+	for i := range wrapped {
+		if i == 0 {
+			wrapped[i] = quote + bullet + strings.TrimRight(wrapped[i], " ")
+		} else {
+			wrapped[i] = quote + hanging + strings.TrimRight(wrapped[i], " ")
+		}
+	}
+	return strings.Join(wrapped, "\n")
+}
+
+// inline renders the formatting and links of a line.
+func (r *dtextRenderer) inline(line string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range dtextInlineRegex.FindAllStringSubmatchIndex(line, -1) {
+		b.WriteString(r.text(line[last:m[0]]))
+		last = m[1]
+		group := func(i int) string {
+			if m[2*i] < 0 {
+				return ""
+			}
+			return line[m[2*i]:m[2*i+1]]
+		}
+
+		switch {
+		case group(1) != "":
+			r.format(strings.ToLower(group(1)))
+		case group(2) != "":
+			b.WriteString(r.link(group(2), dtextLink{linkURL, absoluteURL(group(3))}))
+		case group(4) != "":
+			b.WriteString(r.link(group(4), dtextLink{linkURL, absoluteURL(group(5))}))
+		case group(6) != "":
+			label := group(7)
+			if label == "" {
+				label = group(6)
+			}
+			b.WriteString(r.link(label, dtextLink{linkTag, strings.TrimSpace(group(6))}))
+		case group(8) != "":
+			label := group(9)
+			if label == "" {
+				label = group(8)
+			}
+			tag := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(group(8))), " ", "_")
+			b.WriteString(r.link(label, dtextLink{linkTag, tag}))
+		case group(10) != "":
+			kind := linkPost
+			if strings.EqualFold(group(10), "pool") {
+				kind = linkPool
+			}
+			b.WriteString(r.link(line[m[0]:m[1]], dtextLink{kind, group(11)}))
+		case group(12) != "":
+			url := strings.TrimRight(group(12), ".,;:!?)")
+			last = m[0] + len(url)
+			b.WriteString(r.link(url, dtextLink{linkURL, url}))
+		}
+	}
+	b.WriteString(r.text(line[last:]))
+	return b.String()
+}
+
+// format applies a formatting tag.
+func (r *dtextRenderer) format(tag string) {
+	closing := strings.HasPrefix(tag, "[/")
+	name := strings.Trim(tag, "[/]")
+	if strings.HasPrefix(name, "color") {
+		return // Colors are left to the terminal theme.
+	}
+	switch name {
+	case "b":
+		r.bold = !closing
+	case "i":
+		r.italic = !closing
+	case "u":
+		r.underline = !closing
+	case "s":
+		r.strike = !closing
+	case "spoiler":
+		if closing {
+			r.spoiler = max(r.spoiler-1, 0)
+		} else {
+			r.spoiler++
+		}
+	}
+}
+
+func (r *dtextRenderer) style() lipgloss.Style {
+	style := lipgloss.NewStyle().
+		Bold(r.bold).
+		Italic(r.italic).
+		Underline(r.underline).
+		Strikethrough(r.strike)
+	if r.heading > 0 {
+		style = style.Inherit(dtextHeadingStyle).Underline(r.underline || r.heading == 1)
+	}
+	return style
+}
+
+// hidden reports whether text is currently in a hidden spoiler.
+func (r *dtextRenderer) hidden() bool {
+	return r.spoiler > 0 && !r.state.showSpoilers
+}
+
+// text renders plain text in the current style.
+func (r *dtextRenderer) text(s string) string {
+	if s == "" {
+		return ""
+	}
+	if r.hidden() {
+		return dtextSpoilerStyle.Render(maskSpoiler(s))
+	}
+	style := r.style()
+	if r.spoiler > 0 {
+		style = style.Foreground(dtextSpoilerStyle.GetForeground())
+	}
+	return style.Render(s)
+}
+
+// link renders a link and remembers where it goes. Links in hidden spoilers
+// can't be selected.
+func (r *dtextRenderer) link(label string, l dtextLink) string {
+	if r.hidden() {
+		return r.text(label)
+	}
+	style := dtextLinkStyle.Inherit(r.style())
+	if len(r.links) == r.state.selected {
+		style = style.Reverse(true)
+	}
+	r.links = append(r.links, l)
+	return style.Render(label)
+}
+
+// maskSpoiler hides the letters of a spoiler, keeping the spaces so the text
+// still wraps the same way.
+func maskSpoiler(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return r
+		}
+		return '░'
+	}, s)
+}
+
+// sanitizeText removes the control characters from text sent by the API, other
+// than newlines and tabs, so it can't move the cursor or change the terminal's
+// settings. The \r of \r\n line ends goes too.
+func sanitizeText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// absoluteURL turns links relative to the site into full URLs.
+func absoluteURL(u string) string {
+	if strings.HasPrefix(u, "/") {
+		return apiMirrors.base() + u
+	}
+	return u
+}
+
+// update handles the keys of a rendered text: tab and shift+tab select
+// links and s shows or hides spoilers. It returns the link to follow when
+// enter is pressed, and reports whether the key was used.
+func (s *dtextState) update(msg tea.KeyMsg, links []dtextLink) (*dtextLink, bool) {
+	n := len(links)
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("tab"))):
+		if n > 0 {
+			s.selected = (s.selected + 1) % n
+		}
+	case key.Matches(msg, key.NewBinding(key.WithKeys("shift+tab"))):
+		if n > 0 {
+			s.selected = (max(s.selected, 0) + n - 1) % n
+		}
+	case key.Matches(msg, key.NewBinding(key.WithKeys("s"))):
+		s.showSpoilers = !s.showSpoilers
+		s.selected = -1 // Links in spoilers change the numbering.
+	case key.Matches(msg, key.NewBinding(key.WithKeys("enter"))):
+		if s.selected >= 0 && s.selected < n {
+			return &links[s.selected], true
+		}
+	default:
+		return nil, false
+	}
+	return nil, true
+}
+
+// followLink opens a link: posts, pools and tags are searched for, other
+// links are copied to the clipboard.
+func (m *model) followLink(l dtextLink) tea.Cmd {
+	switch l.kind {
+	case linkPost:
+		return m.searchFor("id:" + l.target)
+	case linkPool:
+		id, _ := strconv.Atoi(l.target)
+		return m.openPool(id, 0)
+	case linkTag:
+		return m.searchFor(l.target)
+	default:
+		m.statusMessage = fmt.Sprintf("Copied %s to clipboard!", l.target)
+		return tea.Batch(copyToClipboardCmd(l.target), clearStatusCmd(2*time.Second))
+	}
+}
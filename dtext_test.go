@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+func TestRenderDTextLinks(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		text  string
+		links []dtextLink
+	}{
+		{"bare url", "see https://example.com/a.png.", "see https://example.com/a.png.", []dtextLink{{linkURL, "https://example.com/a.png"}}},
+		{"labelled url", `"the site":https://example.com`, "the site", []dtextLink{{linkURL, "https://example.com"}}},
+		{"bracketed url", `"the site":[https://example.com/a b]`, "the site", []dtextLink{{linkURL, "https://example.com/a b"}}},
+		{"relative url", `"wiki":/wiki_pages/help`, "wiki", []dtextLink{{linkURL, apiMirrors.base() + "/wiki_pages/help"}}},
+		{"post", "from post #123", "from post #123", []dtextLink{{linkPost, "123"}}},
+		{"pool", "Pool #45 continues", "Pool #45 continues", []dtextLink{{linkPool, "45"}}},
+		{"tag search", "{{cat dog|cats}}", "cats", []dtextLink{{linkTag, "cat dog"}}},
+		{"wiki page", "[[Red Panda]]", "Red Panda", []dtextLink{{linkTag, "red_panda"}}},
+		{"in order", "post #1 and [[fox]]", "post #1 and fox", []dtextLink{{linkPost, "1"}, {linkTag, "fox"}}},
+		{"no links", "just text", "just text", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, links := renderDText(tt.src, 80, newDTextState())
+			if text != tt.text {
+				t.Errorf("text = %q, want %q", text, tt.text)
+			}
+			if !reflect.DeepEqual(links, tt.links) {
+				t.Errorf("links = %v, want %v", links, tt.links)
+			}
+		})
+	}
+}
+
+func TestRenderDTextBlocks(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"quote", "[quote]hello[/quote]\nreply", "│ hello\nreply"},
+		{"multiline quote", "[quote]\nsomeone said\n[/quote]\nreply", "│ someone said\nreply"},
+		{"nested quote", "[quote][quote]a[/quote]\nb[/quote]", "│ │ a\n│ b"},
+		{"quote case", "[QUOTE]a[/Quote]", "│ a"},
+		{"heading", "h2. Title", "Title"},
+		{"list", "* one\n** two", "• one\n  • two"},
+		{"code", "[code]\n[b]raw[/b]\n[/code]", "[b]raw[/b]"},
+		{"formatting", "[b]bold[/b] [i]it[/i] [color=red]red[/color]", "bold it red"},
+		{"section", "[section=Notes]\nhi\n[/section]", "▸ Notes\nhi"},
+		{"crlf", "one\r\ntwo", "one\ntwo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := renderDText(tt.src, 80, newDTextState()); got != tt.want {
+				t.Errorf("renderDText(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderDTextSpoilers(t *testing.T) {
+	src := "the [spoiler]butler did it post #5[/spoiler] post #6"
+
+	hidden, links := renderDText(src, 80, newDTextState())
+	if want := "the ░░░░░░ ░░░ ░░ ░░░░ ░░ post #6"; hidden != want {
+		t.Errorf("hidden spoiler = %q, want %q", hidden, want)
+	}
+	if want := []dtextLink{{linkPost, "6"}}; !reflect.DeepEqual(links, want) {
+		t.Errorf("links with a hidden spoiler = %v, want %v", links, want)
+	}
+
+	state := newDTextState()
+	state.showSpoilers = true
+	shown, links := renderDText(src, 80, state)
+	if want := "the butler did it post #5 post #6"; shown != want {
+		t.Errorf("shown spoiler = %q, want %q", shown, want)
+	}
+	if len(links) != 2 {
+		t.Errorf("got %d links with the spoiler shown, want 2", len(links))
+	}
+}
+
+func TestRenderDTextWrapping(t *testing.T) {
+	src := "[quote]" + strings.Repeat("word ", 20) + "[/quote]\n* " + strings.Repeat("item ", 20)
+	text, _ := renderDText(src, 30, newDTextState())
+	lines := strings.Split(text, "\n")
+	if len(lines) < 4 {
+		t.Fatalf("got %d lines, want the text wrapped:\n%s", len(lines), text)
+	}
+	for _, line := range lines {
+		if w := lipgloss.Width(line); w > 30 {
+			t.Errorf("line %q is %d cells wide, want at most 30", line, w)
+		}
+		if strings.HasPrefix(line, "word") {
+			t.Errorf("wrapped quote line %q lost its bar", line)
+		}
+		if strings.HasPrefix(line, "item") {
+			t.Errorf("wrapped list line %q isn't indented", line)
+		}
+	}
+}
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain text", "plain text"},
+		{"lines\nand\ttabs", "lines\nand\ttabs"},
+		{"crlf\r\nline", "crlf\nline"},
+		{"\x1b[2Jclear", "[2Jclear"},
+		{"title\x1b]0;pwned\x07", "title]0;pwned"},
+		{"c1 \u009b31m csi", "c1 31m csi"},
+		{"del\x7f and nul\x00", "del and nul"},
+		{"ünïcödé ✓", "ünïcödé ✓"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeText(tt.in); got != tt.want {
+			t.Errorf("sanitizeText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	text, _ := renderDText("a\x1b[31mred\x1b[0m [b]b\u009b2J[/b]", 80, newDTextState())
+	if strings.ContainsAny(text, "\x1b\u009b") {
+		t.Errorf("renderDText kept control characters: %q", text)
+	}
+}
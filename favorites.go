@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --- Favorites ---
+
+type favoriteToggledMsg struct {
+	postID    int
+	favorited bool
+	err       error
+}
+
+// favoriteCmd adds the post to the user's favorites, or removes it.
+func favoriteCmd(client *http.Client, host string, postID int, favorite bool) tea.Cmd {
+	return func() tea.Msg {
+		var req *http.Request
+		var err error
+		if favorite {
+			form := url.Values{"post_id": {strconv.Itoa(postID)}}
+			req, err = http.NewRequest("POST", host+"/favorites.json", strings.NewReader(form.Encode()))
+			if req != nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+		} else {
+			req, err = http.NewRequest("DELETE", fmt.Sprintf("%s/favorites/%d.json", host, postID), nil)
+		}
+		if err != nil {
+			return favoriteToggledMsg{postID: postID, favorited: !favorite, err: err}
+		}
+		req.Header.Set("User-Agent", userAgent)
+
+		_, err = fetchAPI(context.Background(), client, req)
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			err = fmt.Errorf("API request failed with %w", statusErr)
+		}
+		if err != nil {
+			log.Printf("Failed to update favorite for post %d: %v", postID, err)
+			return favoriteToggledMsg{postID: postID, favorited: !favorite, err: err}
+		}
+		return favoriteToggledMsg{postID: postID, favorited: favorite}
+	}
+}
+
+// toggleFavorite favorites the selected post, or unfavorites it if it
+// already is.
+func (m *model) toggleFavorite() tea.Cmd {
+	if m.loading || len(m.posts) == 0 || m.postTable.Cursor() >= len(m.posts) {
+		return nil
+	}
+	if m.account == nil {
+		m.statusMessage = "Log in on the main menu to favorite posts"
+		return clearStatusCmd(2 * time.Second)
+	}
+	post := m.posts[m.postTable.Cursor()]
+	return favoriteCmd(m.httpClient, m.apiBase(), post.ID, !post.IsFavorited)
+}
+
+// applyFavorite updates the post in place once e621 confirmed the change.
+func (m *model) applyFavorite(msg favoriteToggledMsg) tea.Cmd {
+	if msg.err != nil {
+		m.statusMessage = fmt.Sprintf("Couldn't update favorites: %v", msg.err)
+		return clearStatusCmd(3 * time.Second)
+	}
+
+	for i := range m.posts {
+		if m.posts[i].ID == msg.postID && m.posts[i].IsFavorited != msg.favorited {
+			m.posts[i].IsFavorited = msg.favorited
+			if msg.favorited {
+				m.posts[i].FavCount++
+			} else {
+				m.posts[i].FavCount--
+			}
+			m.updateTableRows()
+		}
+	}
+	// Don't show the old state when the page is loaded again.
+	if req, err := m.postsRequest(); err == nil {
+		upstreamCache.invalidate(m.postsCacheKey(req))
+	}
+
+	if msg.favorited {
+		m.statusMessage = fmt.Sprintf("Added post #%d to your favorites ♥", msg.postID)
+	} else {
+		m.statusMessage = fmt.Sprintf("Removed post #%d from your favorites", msg.postID)
+	}
+	return clearStatusCmd(2 * time.Second)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- Thumbnail Grid ---
+
+const (
+	// Size of a grid tile in cells, including its border and label.
+	gridTileWidth  = 24
+	gridTileHeight = 12
+	// kittyThumbnailID is the first image id used for thumbnails.
+	kittyThumbnailID = 1000
+)
+
+var (
+	gridTileStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(subtle).
+			Width(gridTileWidth - 2).
+			Height(gridTileHeight - 2)
+	selectedGridTileStyle = gridTileStyle.Copy().
+				BorderForeground(highlight)
+	gridLabelStyle = lipgloss.NewStyle().Foreground(subtle)
+)
+
+type thumbnailLoadedMsg struct {
+	postID int
+	img    image.Image
+}
+
+// gridColumns returns how many tiles fit next to each other.
+func (m *model) gridColumns() int {
+	return max(m.width/gridTileWidth, 1)
+}
+
+// gridRows returns how many rows of tiles fit in the content area.
+func (m *model) gridRows() int {
+	contentHeight := m.height - lipgloss.Height(m.topBarView()) - lipgloss.Height(m.statusBarView())
+	return max(contentHeight/gridTileHeight, 1)
+}
+
+// gridVisible returns the range of post indexes currently on screen.
+func (m *model) gridVisible() (int, int) {
+	first := m.gridOffset * m.gridColumns()
+	last := min(first+m.gridRows()*m.gridColumns(), len(m.posts))
+	return first, last
+}
+
+// toggleGrid switches between the list and grid layouts. The grid shares its
+// selection with the post table, so enter opens the preview of the same post.
+func (m *model) toggleGrid() tea.Cmd {
+	m.gridMode = !m.gridMode
+	if !m.gridMode {
+		m.stopGrid()
+		if len(m.posts) > 0 {
+			return m.triggerPreviewUpdate()
+		}
+		return tea.ClearScreen
+	}
+
+	if m.cancelPreview != nil {
+		m.cancelPreview()
+	}
+	m.animation = nil
+	m.previewViewport.SetContent("")
+	return tea.Batch(tea.ClearScreen, m.resetGrid())
+}
+
+// stopGrid cancels pending thumbnail downloads and forgets the thumbnails.
+func (m *model) stopGrid() {
+	if m.cancelGrid != nil {
+		m.cancelGrid()
+		m.cancelGrid = nil
+	}
+	if m.imageProtocol == protocolKitty && len(m.thumbnailTiles) > 0 {
+		m.writeGraphics(kittyDeleteAll())
+	}
+	m.thumbnails = map[int]image.Image{}
+	m.thumbnailTiles = map[int]string{}
+}
+
+// resetGrid starts over with the current page of posts.
+func (m *model) resetGrid() tea.Cmd {
+	m.stopGrid()
+	var ctx context.Context
+	ctx, m.cancelGrid = context.WithCancel(context.Background())
+	m.gridContext = ctx
+	m.gridOffset = 0
+	m.scrollGridToCursor()
+	return m.fetchVisibleThumbnailsCmd()
+}
+
+// scrollGridToCursor keeps the selected tile on screen.
+func (m *model) scrollGridToCursor() {
+	row := m.postTable.Cursor() / m.gridColumns()
+	if row < m.gridOffset {
+		m.gridOffset = row
+	} else if row >= m.gridOffset+m.gridRows() {
+		m.gridOffset = row - m.gridRows() + 1
+	}
+}
+
+// fetchVisibleThumbnailsCmd downloads the thumbnails on screen that we don't
+// have yet.
+func (m *model) fetchVisibleThumbnailsCmd() tea.Cmd {
+	var cmds []tea.Cmd
+	first, last := m.gridVisible()
+	for _, post := range m.posts[first:last] {
+		if _, ok := m.thumbnails[post.ID]; ok || m.isBlacklisted(post) {
+			continue
+		}
+		// Mark as pending so scrolling back and forth doesn't fetch twice.
+		m.thumbnails[post.ID] = nil
+		cmds = append(cmds, downloadThumbnailCmd(m.gridContext, m.httpClient, post))
+	}
+	return tea.Batch(cmds...)
+}
+
+func downloadThumbnailCmd(ctx context.Context, client *http.Client, post Post) tea.Cmd {
+	return func() tea.Msg {
+		if post.Preview.URL == "" {
+			return nil
+		}
+		data, err := downloadImage(ctx, client, post.Preview.URL)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Printf("Failed to download thumbnail: %v", err)
+			}
+			return nil
+		}
+		img, err := decodeImage(data)
+		if err != nil {
+			log.Printf("Failed to decode thumbnail %s: %v", post.Preview.URL, err)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		return thumbnailLoadedMsg{postID: post.ID, img: img}
+	}
+}
+
+// renderGridTiles encodes the thumbnails on screen for their current
+// position. It needs to run whenever the layout changes. With kitty, the
+// previous thumbnails are freed and the visible ones transmitted again.
+func (m *model) renderGridTiles() {
+	if m.imageProtocol == protocolKitty {
+		m.writeGraphics(kittyDeleteAll())
+	}
+	m.thumbnailTiles = map[int]string{}
+	first, last := m.gridVisible()
+	for i := first; i < last; i++ {
+		m.renderGridTile(i)
+	}
+}
+
+// renderGridTile encodes the thumbnail of the post at index i, if it's on
+// screen and has been downloaded. Kitty thumbnails are transmitted right away
+// and the tile only keeps the escape codes that place them.
+func (m *model) renderGridTile(i int) {
+	first, last := m.gridVisible()
+	if i < first || i >= last {
+		return
+	}
+	post := m.posts[i]
+	img := m.thumbnails[post.ID]
+	if img == nil {
+		return
+	}
+
+	cols := m.gridColumns()
+	box := placement{
+		cols: gridTileWidth - 2,
+		rows: gridTileHeight - 3,
+		x:    (i%cols)*gridTileWidth + 1,
+		y:    lipgloss.Height(m.topBarView()) + (i-first)/cols*gridTileHeight + 1,
+	}
+	var out, transmit string
+	var err error
+	if m.imageProtocol == protocolKitty {
+		transmit, out, err = encodeKittyThumbnail(img, kittyThumbnailID+i, box, m.cellSize)
+	} else {
+		out, err = renderImage(img, m.imageProtocol, box, m.cellSize)
+	}
+	if err != nil {
+		log.Printf("Failed to encode thumbnail for post %d: %v", post.ID, err)
+		return
+	}
+	m.writeGraphics(transmit)
+	m.thumbnailTiles[post.ID] = out
+}
+
+// writeGraphics sends escape codes to the terminal outside of a frame, so
+// they're only sent once instead of with every line that's redrawn.
+func (m *model) writeGraphics(s string) {
+	if s == "" || m.output == nil {
+		return
+	}
+	if _, err := io.WriteString(m.output, s); err != nil {
+		log.Printf("Failed to send graphics: %v", err)
+	}
+}
+
+// updateGrid handles the keys specific to the grid. It reports whether the
+// key was handled, the rest fall through to the regular bindings.
+func (m *model) updateGrid(msg tea.KeyMsg) (bool, tea.Cmd) {
+	cols := m.gridColumns()
+	cursor := m.postTable.Cursor()
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("h", "left"))):
+		cursor--
+	case key.Matches(msg, key.NewBinding(key.WithKeys("l", "right"))):
+		cursor++
+	case key.Matches(msg, key.NewBinding(key.WithKeys("k", "up"))):
+		cursor -= cols
+	case key.Matches(msg, key.NewBinding(key.WithKeys("j", "down"))):
+		cursor += cols
+	case key.Matches(msg, key.NewBinding(key.WithKeys("enter", "g"))):
+		return true, m.toggleGrid()
+	case key.Matches(msg, key.NewBinding(key.WithKeys("["))):
+		return true, m.changePage(-1)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("]"))):
+		return true, m.changePage(1)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("t", "e", "d"))):
+		// The tags popup, details and image resolution only apply to the
+		// preview.
+		return true, nil
+	default:
+		return false, nil
+	}
+
+	if m.loading || len(m.posts) == 0 || cursor < 0 || cursor >= len(m.posts) {
+		return true, nil
+	}
+	m.postTable.SetCursor(cursor)
+	offset := m.gridOffset
+	m.scrollGridToCursor()
+	if offset != m.gridOffset {
+		m.renderGridTiles()
+		return true, tea.Batch(tea.ClearScreen, m.fetchVisibleThumbnailsCmd())
+	}
+	return true, nil
+}
+
+// gridView lays out the tiles on screen. Graphics escape codes for all tiles
+// are emitted at the start of the first line, the same way the preview pane
+// does it, so they're only sent again when that line is redrawn. For kitty
+// these only place the thumbnails that were transmitted before.
+func (m *model) gridView(height int) string {
+	cols := m.gridColumns()
+	first, last := m.gridVisible()
+
+	var graphics strings.Builder
+
+	var rows []string
+	var row []string
+	for i := first; i < last; i++ {
+		post := m.posts[i]
+		tile := m.thumbnailTiles[post.ID]
+
+		content := ""
+		if !m.imageProtocol.graphical() {
+			content = tile
+		} else if tile != "" {
+			graphics.WriteString(tile)
+		}
+		if _, ok := m.thumbnails[post.ID]; ok && tile == "" && post.Preview.URL != "" {
+			content = helpStyle.Render("\nLoading...")
+		}
+		if m.isBlacklisted(post) {
+			content = helpStyle.Render("\nBlacklisted")
+		}
+		content = lipgloss.PlaceVertical(gridTileHeight-3, lipgloss.Top, content)
+
+		label := fmt.Sprintf("#%d ★%s%d", post.ID, m.voteMarker(post.ID), post.Score.Total)
+		if post.IsFavorited {
+			label += " ♥"
+		}
+		content = lipgloss.JoinVertical(lipgloss.Left, content, gridLabelStyle.Render(label))
+
+		style := gridTileStyle
+		if i == m.postTable.Cursor() {
+			style = selectedGridTileStyle
+		}
+		row = append(row, style.Render(content))
+		if len(row) == cols || i == last-1 {
+			rows = append(rows, lipgloss.JoinHorizontal(lipgloss.Top, row...))
+			row = nil
+		}
+	}
+
+	view := lipgloss.PlaceVertical(height, lipgloss.Top, lipgloss.JoinVertical(lipgloss.Left, rows...))
+	if m.imageProtocol.graphical() && graphics.Len() > 0 {
+		view = fmt.Sprintf("\x1b[s%s\x1b[u", graphics.String()) + view
+	}
+	return view
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- Search History ---
+//
+// Searches are remembered with the user's settings, newest first. In the
+// search box, up and down go through them like in a shell and ctrl+r searches
+// them.
+
+const (
+	maxHistory       = 100
+	maxSavedSearches = 9
+	maxSavedNameLen  = 24
+)
+
+// savedSearch is a query the user gave a name, shown on the main menu.
+type savedSearch struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// historySearch is the state of the ctrl+r search through the history.
+type historySearch struct {
+	active   bool
+	input    textinput.Model
+	matches  []string
+	selected int
+}
+
+// addToHistory remembers a query that was searched for.
+func (m *model) addToHistory(query string) {
+	query = strings.TrimSpace(query)
+	m.historyIndex = -1
+	if query == "" {
+		return
+	}
+	history := []string{query}
+	for _, q := range m.settings.History {
+		if q != query && len(history) < maxHistory {
+			history = append(history, q)
+		}
+	}
+	m.settings.History = history
+	m.saveSettings()
+}
+
+// setSearchText replaces the query in the search box without suggesting tags
+// for it.
+func (m *model) setSearchText(query string) {
+	m.searchBox.SetValue(query)
+	m.searchBox.CursorEnd()
+	m.suppressAutocomplete()
+}
+
+// historyKey handles the history keys of the search box: up and down go
+// through older searches and ctrl+r searches them. It reports whether the key
+// was used.
+func (m *model) historyKey(msg tea.KeyMsg) (tea.Cmd, bool) {
+	history := m.settings.History
+	if len(history) == 0 {
+		return nil, false
+	}
+	// The query may have been edited or replaced since the last step.
+	if m.historyIndex >= len(history) || (m.historyIndex >= 0 && history[m.historyIndex] != m.searchBox.Value()) {
+		m.historyIndex = -1
+	}
+
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("up"))):
+		if m.historyIndex == -1 {
+			m.historyDraft = m.searchBox.Value()
+		}
+		if m.historyIndex < len(history)-1 {
+			m.historyIndex++
+			m.setSearchText(history[m.historyIndex])
+		}
+	case key.Matches(msg, key.NewBinding(key.WithKeys("down"))):
+		if m.historyIndex == -1 {
+			return nil, true
+		}
+		m.historyIndex--
+		if m.historyIndex == -1 {
+			m.setSearchText(m.historyDraft)
+		} else {
+			m.setSearchText(history[m.historyIndex])
+		}
+	case key.Matches(msg, key.NewBinding(key.WithKeys("ctrl+r"))):
+		return m.openHistorySearch(), true
+	default:
+		return nil, false
+	}
+	return nil, true
+}
+
+func (m *model) openHistorySearch() tea.Cmd {
+	m.clearAutocomplete()
+	input := textinput.New()
+	input.Prompt = "history: "
+	input.Placeholder = "type to search"
+	input.Cursor.SetMode(cursor.CursorStatic)
+	m.historySearch = historySearch{active: true, input: input}
+	m.filterHistory()
+	return m.historySearch.input.Focus()
+}
+
+// updateHistorySearch handles keys while the history is searched.
+func (m *model) updateHistorySearch(msg tea.KeyMsg) tea.Cmd {
+	hs := &m.historySearch
+	n := len(hs.matches)
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("esc"))):
+		hs.active = false
+	case key.Matches(msg, key.NewBinding(key.WithKeys("enter", "tab"))):
+		if n > 0 {
+			m.setSearchText(hs.matches[hs.selected])
+		}
+		hs.active = false
+	case key.Matches(msg, key.NewBinding(key.WithKeys("up", "ctrl+p"))):
+		if n > 0 {
+			hs.selected = (hs.selected + n - 1) % n
+		}
+	case key.Matches(msg, key.NewBinding(key.WithKeys("down", "ctrl+n", "ctrl+r"))):
+		if n > 0 {
+			hs.selected = (hs.selected + 1) % n
+		}
+	default:
+		var cmd tea.Cmd
+		hs.input, cmd = hs.input.Update(msg)
+		m.filterHistory()
+		return cmd
+	}
+	return nil
+}
+
+// filterHistory finds the searches matching what's typed, best first.
+func (m *model) filterHistory() {
+	hs := &m.historySearch
+	pattern := hs.input.Value()
+	type match struct {
+		query string
+		score int
+	}
+	var matches []match
+	for _, q := range m.settings.History {
+		if score, ok := fuzzyScore(pattern, q); ok {
+			matches = append(matches, match{q, score})
+		}
+	}
+	// Equal scores keep their order, newest first.
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+
+	hs.matches = hs.matches[:0]
+	for _, match := range matches {
+		hs.matches = append(hs.matches, match.query)
+	}
+	hs.selected = 0
+}
+
+// fuzzyScore reports whether the letters of pattern appear in s in order,
+// and how well they match. Consecutive letters and letters at the start of a
+// tag score higher.
+func fuzzyScore(pattern, s string) (int, bool) {
+	p := []rune(strings.ToLower(pattern))
+	if len(p) == 0 {
+		return 0, true
+	}
+	score, i := 0, 0
+	prevMatched := false
+	prev := ' '
+	for _, r := range strings.ToLower(s) {
+		if i < len(p) && r == p[i] {
+			score++
+			if prevMatched {
+				score += 2
+			}
+			if unicode.IsSpace(prev) || prev == '_' || prev == ':' {
+				score += 3
+			}
+			i++
+			prevMatched = true
+		} else {
+			prevMatched = false
+		}
+		prev = r
+	}
+	return score, i == len(p)
+}
+
+// historySearchView renders the ctrl+r search with its matches.
+func (m *model) historySearchView(width int) string {
+	hs := &m.historySearch
+	inner := width - 4
+	lines := []string{hs.input.View()}
+	if len(hs.matches) == 0 {
+		lines = append(lines, helpStyle.Render("No matching searches"))
+	}
+	for i, q := range hs.matches[:min(len(hs.matches), maxSuggestions)] {
+		q = truncate(q, inner-2)
+		if i == hs.selected {
+			lines = append(lines, "› "+lipgloss.NewStyle().Foreground(highlight).Render(q))
+		} else {
+			lines = append(lines, "  "+q)
+		}
+	}
+	return autocompleteStyle.Width(width - 2).Render(strings.Join(lines, "\n"))
+}
+
+// searchDropdownView renders whatever is shown under the search box: the
+// history search or tag suggestions.
+func (m *model) searchDropdownView(width int) string {
+	if m.historySearch.active {
+		return m.historySearchView(width)
+	}
+	return m.autocompleteView(width)
+}
+
+// --- Saved Searches ---
+
+// savedSearchAt returns the saved search of a main menu button.
+func (m *model) savedSearchAt(button int) (savedSearch, bool) {
+	i := button - len(m.menuPresets())
+	if i < 0 || i >= len(m.settings.SavedSearches) {
+		return savedSearch{}, false
+	}
+	return m.settings.SavedSearches[i], true
+}
+
+// openSaveSearch asks for a name to save the current query under.
+func (m *model) openSaveSearch() tea.Cmd {
+	if strings.TrimSpace(m.query) == "" {
+		m.statusMessage = "Search for something first to save it"
+		return clearStatusCmd(2 * time.Second)
+	}
+	m.searchNameInput = textinput.New()
+	m.searchNameInput.Prompt = "Save search as: "
+	m.searchNameInput.CharLimit = maxSavedNameLen
+	m.searchNameInput.SetValue(m.query)
+	m.searchNameInput.CursorEnd()
+	m.savingSearch = true
+	return m.searchNameInput.Focus()
+}
+
+// updateSaveSearch handles keys while the name is typed.
+func (m *model) updateSaveSearch(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("esc"))):
+		m.savingSearch = false
+		return nil
+	case key.Matches(msg, key.NewBinding(key.WithKeys("enter"))):
+		name := strings.TrimSpace(m.searchNameInput.Value())
+		if name == "" {
+			return nil
+		}
+		m.savingSearch = false
+		return m.saveSearch(name, m.query)
+	}
+	var cmd tea.Cmd
+	m.searchNameInput, cmd = m.searchNameInput.Update(msg)
+	return cmd
+}
+
+// saveSearch adds a saved search, replacing one with the same name.
+func (m *model) saveSearch(name, query string) tea.Cmd {
+	saved := m.settings.SavedSearches
+	replaced := false
+	for i := range saved {
+		if strings.EqualFold(saved[i].Name, name) {
+			saved[i] = savedSearch{Name: name, Query: query}
+			replaced = true
+		}
+	}
+	if !replaced {
+		if len(saved) >= maxSavedSearches {
+			m.statusMessage = fmt.Sprintf("You can save up to %d searches, delete one on the main menu first", maxSavedSearches)
+			return clearStatusCmd(3 * time.Second)
+		}
+		saved = append(saved, savedSearch{Name: name, Query: query})
+	}
+	m.settings.SavedSearches = saved
+	m.saveSettings()
+
+	m.statusMessage = fmt.Sprintf("Saved search %q", name)
+	if settings == nil || m.userID == "" {
+		m.statusMessage += " for this session, connect with an SSH key to keep it"
+	}
+	return clearStatusCmd(2 * time.Second)
+}
+
+// deleteSavedSearch removes the saved search of a main menu button.
+func (m *model) deleteSavedSearch(button int) {
+	if _, ok := m.savedSearchAt(button); !ok {
+		return
+	}
+	i := button - len(m.menuPresets())
+	m.settings.SavedSearches = append(m.settings.SavedSearches[:i:i], m.settings.SavedSearches[i+1:]...)
+	m.saveSettings()
+	m.selectedButton = min(m.selectedButton, len(m.menuButtons())-1)
+}
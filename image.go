@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// --- Image Protocols ---
+
+// imageProtocol is the way images are drawn in a session's terminal.
+type imageProtocol int
+
+const (
+	protocolKitty imageProtocol = iota
+	protocolSixel
+	protocolITerm
+	protocolBlocks
+	protocolBlocks256
+	protocolBraille
+	protocolNone
+	protocolCount // Number of protocols, used for cycling through them.
+)
+
+func (p imageProtocol) String() string {
+	switch p {
+	case protocolKitty:
+		return "kitty"
+	case protocolSixel:
+		return "sixel"
+	case protocolITerm:
+		return "iterm2"
+	case protocolBlocks:
+		return "blocks"
+	case protocolBlocks256:
+		return "blocks256"
+	case protocolBraille:
+		return "braille"
+	case protocolNone:
+		return "none"
+	default:
+		return "unknown"
+	}
+}
+
+// graphical reports whether the protocol draws pixels with escape codes
+// rather than characters.
+func (p imageProtocol) graphical() bool {
+	return p == protocolKitty || p == protocolSixel || p == protocolITerm
+}
+
+// next returns the protocol after p, wrapping around.
+func (p imageProtocol) next() imageProtocol {
+	return (p + 1) % protocolCount
+}
+
+// renderImage encodes img for the given protocol so that it's drawn centred
+// within box. Text protocols ignore the box offsets as their output is
+// placed by the viewport.
+func renderImage(img image.Image, proto imageProtocol, box placement, cell cellSize) (string, error) {
+	switch proto {
+	case protocolKitty:
+		return encodeKitty(img, kittyPreviewID, box, cell)
+	case protocolSixel:
+		return encodeSixel(img, box, cell)
+	case protocolITerm:
+		return encodeITerm(img, box, cell)
+	case protocolBlocks:
+		return encodeText(img, textHalfBlocks, box.cols, box.rows, cell)
+	case protocolBlocks256:
+		return encodeText(img, textHalfBlocks256, box.cols, box.rows, cell)
+	case protocolBraille:
+		return encodeText(img, textBraille, box.cols, box.rows, cell)
+	default:
+		return "", fmt.Errorf("unsupported image protocol %v", proto)
+	}
+}
+
+// --- Image Geometry ---
+
+// cellSize is the size of a single terminal cell in pixels.
+type cellSize struct {
+	w, h int
+}
+
+// defaultCellSize is used when the client doesn't report its pixel dimensions.
+var defaultCellSize = cellSize{w: 10, h: 20}
+
+// placement describes a rectangular area of the terminal in cells.
+// X and Y are zero-based column and row offsets from the top left corner.
+type placement struct {
+	cols, rows int
+	x, y       int
+}
+
+// fittedImage is the result of fitting an image into a placement while
+// preserving its aspect ratio, centred the same way `icat --align=center
+// --scale-up` used to do it.
+type fittedImage struct {
+	pxW, pxH   int // Target size of the scaled image in pixels.
+	cols, rows int // Number of cells the scaled image covers.
+	x, y       int // Top left cell of the centred image.
+}
+
+// cellSizeFromWindow derives the cell size from the pixel dimensions a client
+// sent along with its pty request, falling back to defaultCellSize.
+func cellSizeFromWindow(cols, rows, pxW, pxH int) cellSize {
+	if cols <= 0 || rows <= 0 || pxW <= 0 || pxH <= 0 {
+		return defaultCellSize
+	}
+	cs := cellSize{w: pxW / cols, h: pxH / rows}
+	if cs.w <= 0 || cs.h <= 0 {
+		return defaultCellSize
+	}
+	return cs
+}
+
+// fitImage scales an image of imgW x imgH pixels up or down so it fits the
+// placement, and centres it within it.
+func fitImage(imgW, imgH int, box placement, cell cellSize) fittedImage {
+	if imgW <= 0 || imgH <= 0 || box.cols <= 0 || box.rows <= 0 {
+		return fittedImage{}
+	}
+	boxW := box.cols * cell.w
+	boxH := box.rows * cell.h
+
+	// Compare imgW/imgH against boxW/boxH without floating point.
+	var pxW, pxH int
+	if imgW*boxH >= imgH*boxW {
+		pxW = boxW
+		pxH = max(imgH*boxW/imgW, 1)
+	} else {
+		pxH = boxH
+		pxW = max(imgW*boxH/imgH, 1)
+	}
+
+	cols := min((pxW+cell.w-1)/cell.w, box.cols)
+	rows := min((pxH+cell.h-1)/cell.h, box.rows)
+	return fittedImage{
+		pxW:  pxW,
+		pxH:  pxH,
+		cols: cols,
+		rows: rows,
+		x:    box.x + (box.cols-cols)/2,
+		y:    box.y + (box.rows-rows)/2,
+	}
+}
+
+// --- Image Decoding & Scaling ---
+
+// decodeImage decodes a downloaded image in any of the registered formats.
+func decodeImage(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// toRGBA returns img as an *image.RGBA with its origin at (0, 0).
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
+
+// scaleImage resizes img to w x h pixels. Each destination pixel is the
+// average of the source pixels it covers, which keeps downscaled photos from
+// looking noisy; when upscaling this degrades to nearest neighbour.
+func scaleImage(img image.Image, w, h int) *image.RGBA {
+	src := toRGBA(img)
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if w == sw && h == sh {
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if sw == 0 || sh == 0 {
+		return dst
+	}
+
+	for dy := 0; dy < h; dy++ {
+		y0 := dy * sh / h
+		y1 := max((dy+1)*sh/h, y0+1)
+		for dx := 0; dx < w; dx++ {
+			x0 := dx * sw / w
+			x1 := max((dx+1)*sw/w, x0+1)
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				off := sy*src.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(src.Pix[off])
+					g += uint32(src.Pix[off+1])
+					b += uint32(src.Pix[off+2])
+					a += uint32(src.Pix[off+3])
+					n++
+					off += 4
+				}
+			}
+			o := dy*dst.Stride + dx*4
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(b / n)
+			dst.Pix[o+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFitImage(t *testing.T) {
+	cell := cellSize{w: 10, h: 20}
+	tests := []struct {
+		name       string
+		imgW, imgH int
+		box        placement
+		want       fittedImage
+	}{
+		{
+			name: "wide image fills the width",
+			imgW: 400, imgH: 100,
+			box:  placement{cols: 20, rows: 10},
+			want: fittedImage{pxW: 200, pxH: 50, cols: 20, rows: 3, x: 0, y: 3},
+		},
+		{
+			name: "tall image fills the height",
+			imgW: 100, imgH: 400,
+			box:  placement{cols: 20, rows: 10},
+			want: fittedImage{pxW: 50, pxH: 200, cols: 5, rows: 10, x: 7, y: 0},
+		},
+		{
+			name: "small image is scaled up",
+			imgW: 2, imgH: 2,
+			box:  placement{cols: 4, rows: 2},
+			want: fittedImage{pxW: 40, pxH: 40, cols: 4, rows: 2, x: 0, y: 0},
+		},
+		{
+			name: "offsets are kept",
+			imgW: 100, imgH: 400,
+			box:  placement{cols: 20, rows: 10, x: 3, y: 5},
+			want: fittedImage{pxW: 50, pxH: 200, cols: 5, rows: 10, x: 10, y: 5},
+		},
+		{
+			name: "very thin image keeps a pixel",
+			imgW: 10000, imgH: 1,
+			box:  placement{cols: 10, rows: 10},
+			want: fittedImage{pxW: 100, pxH: 1, cols: 10, rows: 1, x: 0, y: 4},
+		},
+		{
+			name: "empty image",
+			imgW: 0, imgH: 100,
+			box: placement{cols: 10, rows: 10},
+		},
+		{
+			name: "empty box",
+			imgW: 100, imgH: 100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fitImage(tt.imgW, tt.imgH, tt.box, cell); got != tt.want {
+				t.Errorf("fitImage(%d, %d, %+v) = %+v, want %+v", tt.imgW, tt.imgH, tt.box, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScaleImage(t *testing.T) {
+	// Left half black, right half white.
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			v := uint8(0)
+			if x >= 2 {
+				v = 0xff
+			}
+			src.Set(x, y, color.RGBA{v, v, v, 0xff})
+		}
+	}
+
+	tests := []struct {
+		name string
+		w, h int
+		want []uint8 // Red channel of each pixel, row by row.
+	}{
+		{"same size", 4, 2, []uint8{0, 0, 0xff, 0xff, 0, 0, 0xff, 0xff}},
+		{"downscaled", 2, 1, []uint8{0, 0xff}},
+		{"averaged", 1, 1, []uint8{0x7f}},
+		{"upscaled", 8, 1, []uint8{0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := scaleImage(src, tt.w, tt.h)
+			if b := dst.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+				t.Fatalf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.w, tt.h)
+			}
+			var got []uint8
+			for y := 0; y < tt.h; y++ {
+				for x := 0; x < tt.w; x++ {
+					got = append(got, dst.RGBAAt(x, y).R)
+				}
+			}
+			if string(got) != string(tt.want) {
+				t.Errorf("pixels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+)
+
+// --- iTerm2 Inline Images ---
+//
+// See https://iterm2.com/documentation-images.html for the spec.
+
+// encodeITerm scales img to fit box and returns the escape codes that move
+// the cursor to the centred position and draw the image there using the
+// iTerm2 inline image protocol.
+func encodeITerm(img image.Image, box placement, cell cellSize) (string, error) {
+	b := img.Bounds()
+	fit := fitImage(b.Dx(), b.Dy(), box, cell)
+	if fit.cols == 0 || fit.rows == 0 {
+		return "", fmt.Errorf("no room to draw image in %dx%d cells", box.cols, box.rows)
+	}
+
+	// We scale the image ourselves so it's also enlarged when it's smaller
+	// than the pane, and so we don't send more pixels than can be shown.
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleImage(img, fit.pxW, fit.pxH)); err != nil {
+		return "", fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return moveCursor(fit.x, fit.y) + fmt.Sprintf(
+		"\x1b]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=1;doNotMoveCursor=1:%s\a",
+		buf.Len(), fit.cols, fit.rows, base64.StdEncoding.EncodeToString(buf.Bytes()),
+	), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+)
+
+// --- Kitty Graphics Protocol ---
+//
+// See https://sw.kovidgoyal.net/kitty/graphics-protocol/ for the spec.
+
+const (
+	// kittyChunkSize is the maximum payload size of a single escape code.
+	kittyChunkSize = 4096
+	// kittyPreviewID is the image id used for the preview pane. Reusing the
+	// same id lets us delete the previous preview before drawing a new one.
+	kittyPreviewID = 1
+	// kittyZIndex draws images below the text so the UI stays readable.
+	kittyZIndex = -5
+)
+
+// kittyCommand builds a single graphics escape code from its control data
+// and optional payload.
+func kittyCommand(control, payload string) string {
+	if payload == "" {
+		return "\x1b_G" + control + "\x1b\\"
+	}
+	return "\x1b_G" + control + ";" + payload + "\x1b\\"
+}
+
+// kittyDelete frees the image with the given id along with all its placements.
+func kittyDelete(id int) string {
+	return kittyCommand(fmt.Sprintf("a=d,d=I,i=%d,q=2", id), "")
+}
+
+// kittyDeleteAll frees every image, so that a new preview doesn't end up on
+// top of the previous one, its animation frames or the grid's thumbnails.
+func kittyDeleteAll() string {
+	return kittyCommand("a=d,d=A,q=2", "")
+}
+
+// kittyTransmit sends PNG data to the terminal in base64 chunks. The first
+// chunk carries the control data, the rest only the continuation flag.
+func kittyTransmit(control string, data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var sb strings.Builder
+	for first := true; first || encoded != ""; first = false {
+		chunk := encoded[:min(kittyChunkSize, len(encoded))]
+		encoded = encoded[len(chunk):]
+
+		more := 0
+		if encoded != "" {
+			more = 1
+		}
+		if first {
+			sb.WriteString(kittyCommand(fmt.Sprintf("%s,m=%d", control, more), chunk))
+		} else {
+			sb.WriteString(kittyCommand(fmt.Sprintf("m=%d", more), chunk))
+		}
+	}
+	return sb.String()
+}
+
+// encodeKitty scales img to fit box and returns the escape codes that delete
+// the previous image with the same id, move the cursor to the centred
+// position and transmit and display the new image there.
+func encodeKitty(img image.Image, id int, box placement, cell cellSize) (string, error) {
+	fit, data, err := scaleKitty(img, box, cell)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(kittyDelete(id))
+	sb.WriteString(moveCursor(fit.x, fit.y))
+	control := fmt.Sprintf("a=T,f=100,i=%d,c=%d,r=%d,z=%d,C=1,q=2", id, fit.cols, fit.rows, kittyZIndex)
+	sb.WriteString(kittyTransmit(control, data))
+	return sb.String(), nil
+}
+
+// encodeKittyThumbnail is like encodeKitty, but splits transmitting the image
+// from displaying it. The image only needs to be sent once, while placing it
+// again is cheap and moves the existing placement instead of adding one.
+func encodeKittyThumbnail(img image.Image, id int, box placement, cell cellSize) (transmit, place string, err error) {
+	fit, data, err := scaleKitty(img, box, cell)
+	if err != nil {
+		return "", "", err
+	}
+
+	transmit = kittyTransmit(fmt.Sprintf("a=t,f=100,i=%d,q=2", id), data)
+	control := fmt.Sprintf("a=p,i=%d,p=1,c=%d,r=%d,z=%d,C=1,q=2", id, fit.cols, fit.rows, kittyZIndex)
+	place = moveCursor(fit.x, fit.y) + kittyCommand(control, "")
+	return transmit, place, nil
+}
+
+// scaleKitty scales img to fit box and encodes it as PNG.
+func scaleKitty(img image.Image, box placement, cell cellSize) (fittedImage, []byte, error) {
+	b := img.Bounds()
+	fit := fitImage(b.Dx(), b.Dy(), box, cell)
+	if fit.cols == 0 || fit.rows == 0 {
+		return fit, nil, fmt.Errorf("no room to draw image in %dx%d cells", box.cols, box.rows)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleImage(img, fit.pxW, fit.pxH)); err != nil {
+		return fit, nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return fit, buf.Bytes(), nil
+}
+
+// moveCursor returns the escape code that moves the cursor to the zero-based
+// cell at column x, row y.
+func moveCursor(x, y int) string {
+	return fmt.Sprintf("\x1b[%d;%dH", y+1, x+1)
+}
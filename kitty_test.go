@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// splitKitty splits escape codes into their control data and payloads.
+func splitKitty(t *testing.T, s string) (controls, payloads []string) {
+	t.Helper()
+	for _, code := range strings.SplitAfter(s, "\x1b\\") {
+		if code == "" {
+			continue
+		}
+		body, ok := strings.CutPrefix(code, "\x1b_G")
+		if !ok {
+			t.Fatalf("escape code %q doesn't start with APC G", code)
+		}
+		body = strings.TrimSuffix(body, "\x1b\\")
+		control, payload, _ := strings.Cut(body, ";")
+		controls = append(controls, control)
+		payloads = append(payloads, payload)
+	}
+	return controls, payloads
+}
+
+func TestKittyTransmit(t *testing.T) {
+	// Sizes of the base64 payload, 4 characters per 3 bytes.
+	tests := []struct {
+		name     string
+		size     int
+		controls []string
+	}{
+		{"empty", 0, []string{"a=T,m=0"}},
+		{"one chunk", 30, []string{"a=T,m=0"}},
+		{"exactly one chunk", kittyChunkSize / 4 * 3, []string{"a=T,m=0"}},
+		{"one byte over", kittyChunkSize/4*3 + 1, []string{"a=T,m=1", "m=0"}},
+		{"three chunks", kittyChunkSize/4*3*2 + 10, []string{"a=T,m=1", "m=1", "m=0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte{0xab}, tt.size)
+			controls, payloads := splitKitty(t, kittyTransmit("a=T", data))
+			if strings.Join(controls, " ") != strings.Join(tt.controls, " ") {
+				t.Errorf("controls = %q, want %q", controls, tt.controls)
+			}
+			for i, p := range payloads {
+				if len(p) > kittyChunkSize {
+					t.Errorf("chunk %d is %d bytes, more than %d", i, len(p), kittyChunkSize)
+				}
+			}
+			decoded, err := base64.StdEncoding.DecodeString(strings.Join(payloads, ""))
+			if err != nil {
+				t.Fatalf("payload isn't base64: %v", err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Errorf("payload decodes to %d bytes, want %d", len(decoded), len(data))
+			}
+		})
+	}
+}
+
+func TestKittyCommands(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{kittyCommand("a=d", ""), "\x1b_Ga=d\x1b\\"},
+		{kittyCommand("m=0", "QUJD"), "\x1b_Gm=0;QUJD\x1b\\"},
+		{kittyDelete(3), "\x1b_Ga=d,d=I,i=3,q=2\x1b\\"},
+		{kittyDeleteAll(), "\x1b_Ga=d,d=A,q=2\x1b\\"},
+		{moveCursor(0, 0), "\x1b[1;1H"},
+		{moveCursor(4, 2), "\x1b[3;5H"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestEncodeKitty(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.Set(0, 0, color.RGBA{R: 0xff, A: 0xff})
+
+	// A 2:1 image in a 10x10 box of 10x20 pixel cells is 100x50 pixels,
+	// covering 10x3 cells centred at row 3.
+	box := placement{cols: 10, rows: 10, x: 2, y: 1}
+	out, err := encodeKitty(img, 7, box, cellSize{w: 10, h: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := kittyDelete(7) + moveCursor(2, 4)
+	if !strings.HasPrefix(out, prefix) {
+		t.Fatalf("output starts with %q, want %q", out[:min(len(out), len(prefix))], prefix)
+	}
+	controls, payloads := splitKitty(t, strings.TrimPrefix(out, prefix))
+	if want := "a=T,f=100,i=7,c=10,r=3,z=-5,C=1,q=2,m=0"; controls[0] != want {
+		t.Errorf("control = %q, want %q", controls[0], want)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.Join(payloads, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("payload isn't a PNG: %v", err)
+	}
+	if b := decoded.Bounds(); b.Dx() != 100 || b.Dy() != 50 {
+		t.Errorf("image is %dx%d, want 100x50", b.Dx(), b.Dy())
+	}
+
+	if _, err := encodeKitty(img, 7, placement{}, cellSize{w: 10, h: 20}); err == nil {
+		t.Error("encoding into an empty box succeeded")
+	}
+}
+
+func TestEncodeKittyThumbnail(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	box := placement{cols: 10, rows: 10, x: 2, y: 1}
+	transmit, place, err := encodeKittyThumbnail(img, 7, box, cellSize{w: 10, h: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controls, payloads := splitKitty(t, transmit)
+	if want := "a=t,f=100,i=7,q=2,m=0"; controls[0] != want {
+		t.Errorf("transmit control = %q, want %q", controls[0], want)
+	}
+	if strings.Join(payloads, "") == "" {
+		t.Error("transmit has no image data")
+	}
+
+	want := moveCursor(2, 4) + kittyCommand("a=p,i=7,p=1,c=10,r=3,z=-5,C=1,q=2", "")
+	if place != want {
+		t.Errorf("place = %q, want %q", place, want)
+	}
+}
@@ -5,13 +5,14 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"image"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
-	"regexp"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -19,6 +20,7 @@ import (
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
@@ -27,13 +29,13 @@ import (
 	"github.com/charmbracelet/wish"
 	"github.com/charmbracelet/wish/bubbletea"
 	"github.com/charmbracelet/wish/logging"
+	"github.com/muesli/termenv"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 // --- Configuration ---
 const (
-	host        = "0.0.0.0"
-	apiEndpoint = "https://e621.net/posts.json"
-	userAgent   = "e6tea1/v3 t.me/TankKittyCat"
+	host = "0.0.0.0"
 )
 
 // --- ASCII Art ---
@@ -58,6 +60,8 @@ type Post struct {
 	ID    int   `json:"id"`
 	Pools []int `json:"pools"`
 	Score struct {
+		Up    int `json:"up"`
+		Down  int `json:"down"`
 		Total int `json:"total"`
 	} `json:"score"`
 	Rating string `json:"rating"`
@@ -75,59 +79,141 @@ type Post struct {
 		URL    string `json:"url"`
 		Width  int    `json:"width"`
 		Height int    `json:"height"`
+		Ext    string `json:"ext"`
+		Size   int64  `json:"size"`
+		MD5    string `json:"md5"`
 	} `json:"file"`
+	Preview struct {
+		URL    string `json:"url"`
+		Width  int    `json:"width"`
+		Height int    `json:"height"`
+	} `json:"preview"`
 	Sample struct {
 		URL string `json:"url"`
 		Has bool   `json:"has"`
 	} `json:"sample"`
+	FavCount    int  `json:"fav_count"`
+	IsFavorited bool `json:"is_favorited"`
+
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Description  string    `json:"description"`
+	Sources      []string  `json:"sources"`
+	LockedTags   []string  `json:"locked_tags"`
+	CommentCount int       `json:"comment_count"`
+	UploaderID   int       `json:"uploader_id"`
+	ApproverID   *int      `json:"approver_id"`
+	Duration     *float64  `json:"duration"` // Seconds, for videos.
+	Flags        struct {
+		Pending      bool `json:"pending"`
+		Flagged      bool `json:"flagged"`
+		Deleted      bool `json:"deleted"`
+		NoteLocked   bool `json:"note_locked"`
+		StatusLocked bool `json:"status_locked"`
+		RatingLocked bool `json:"rating_locked"`
+	} `json:"flags"`
+	Relationships struct {
+		ParentID          *int  `json:"parent_id"`
+		HasChildren       bool  `json:"has_children"`
+		HasActiveChildren bool  `json:"has_active_children"`
+		Children          []int `json:"children"`
+	} `json:"relationships"`
 }
 
 // --- Bubble Tea Model ---
 type model struct {
+	account          *e621User // Nil unless logged in.
+	animation        *renderedAnimation
+	animationCtx     context.Context
+	animationFrame   int
+	autocomplete     autocomplete
+	blacklist        blacklist
+	blacklistEditor  textarea.Model
+	blacklistText    string
+	cancelGrid       context.CancelFunc
 	cancelPreview    context.CancelFunc
+	cancelPrefetch   context.CancelFunc
+	cellSize         cellSize
+	comments         commentsView
+	credentials      *credentials
+	detailsScroll    int
+	detailsText      dtextState // Links and spoilers of the post description.
+	editingBlacklist bool
 	err              error
+	gridContext      context.Context
+	gridMode         bool
+	gridOffset       int    // First visible row of tiles.
+	historyDraft     string // The query that was typed before going through the history.
+	historyIndex     int    // Position in the history shown in the search box, -1 if none.
+	historySearch    historySearch
 	httpClient       *http.Client
+	imageProtocol    imageProtocol
 	loading          bool
+	login            loginForm
 	onEntranceScreen bool
+	output           io.Writer // The session, for escape sequences that aren't part of a frame.
+	pool             poolReader
 	posts            []Post
+	prefetchCtx      context.Context
+	previewCache     *previewCache
 	previewViewport  viewport.Model
 	query            string
 	quitting         bool
+	retryAt          time.Time // When the failed fetch of posts is retried.
+	retryReason      string
+	safeMode         bool
+	savingSearch     bool
 	searchBox        textinput.Model
-	selectedButton   int // 0 for Latest, 1 for Popular
+	searchNameInput  textinput.Model
+	selectedButton   int // Index into menuButtons.
+	settings         userSettings
+	savedSettings    userSettings // The settings as last loaded or saved.
 	showFullImage    bool
 	spinner          spinner.Model
 	statusMessage    string
-	postTable        table.Model // Renamed from 'table' for clarity
-	tagViewport      viewport.Model
+	postTable        table.Model         // Renamed from 'table' for clarity
+	thumbnails       map[int]image.Image // Keyed by post ID, nil while loading.
+	thumbnailTiles   map[int]string      // Encoded thumbnails, keyed by post ID.
+	showDetails      bool
 	showTags         bool
-	currentTags      string
+	tagCursor        int
+	tagList          []tagEntry // Tags of the selected post, for the tags popup.
 	currentPage      int
-	jumpToPostID     int
+	userID           string      // Identifies returning users, empty if we can't.
+	votes            map[int]int // Votes cast this session, keyed by post ID.
 	width, height    int
 }
 
 // --- Messages ---
 type postsFetchedMsg struct{ posts []Post }
 type previewLoadedMsg struct{ content string }
+type animationLoadedMsg struct {
+	ctx  context.Context
+	anim *renderedAnimation
+}
+type animationTickMsg struct{ ctx context.Context }
 type errorMsg struct{ err error }
+type retryPostsMsg struct {
+	attempt int
+	delay   time.Duration
+	err     error
+}
+type retryPostsDueMsg struct{ attempt int }
 type clearStatusMsg struct{}
 
 // --- Styles & View ---
 
 var (
 	// Colors
-	background = lipgloss.Color("#282a36")
-	foreground = lipgloss.Color("#f8f8f2")
-	highlight  = lipgloss.Color("#bd93f9")
-	subtle     = lipgloss.Color("#6272a4")
-	text       = lipgloss.Color("#f8f8f2")
-	keyColor   = lipgloss.Color("#50fa7b")
-	valueColor = lipgloss.Color("#f1fa8c")
-	errorColor = lipgloss.Color("#ff5555")
-
-	// A regular expression for parsing key:value pairs in search queries.
-	filterRegex = regexp.MustCompile(`(?P<key>\S*):(?P<value>\S*)`)
+	background   = lipgloss.Color("#282a36")
+	foreground   = lipgloss.Color("#f8f8f2")
+	highlight    = lipgloss.Color("#bd93f9")
+	subtle       = lipgloss.Color("#6272a4")
+	text         = lipgloss.Color("#f8f8f2")
+	keyColor     = lipgloss.Color("#50fa7b")
+	valueColor   = lipgloss.Color("#f1fa8c")
+	errorColor   = lipgloss.Color("#ff5555")
+	warningColor = lipgloss.Color("#ffb86c")
 
 	// General Styles
 	appStyle = lipgloss.NewStyle().
@@ -137,6 +223,7 @@ var (
 	defaultTextStyle = lipgloss.NewStyle().Foreground(text)
 	helpStyle        = lipgloss.NewStyle().Foreground(subtle)
 	spinnerStyle     = lipgloss.NewStyle().Foreground(highlight)
+	safeModeStyle    = lipgloss.NewStyle().Foreground(background).Background(keyColor).Bold(true).Padding(0, 1)
 
 	// Pane & Box Styles
 	paneStyle = lipgloss.NewStyle().
@@ -186,11 +273,13 @@ var (
 // --- Table Configurations ---
 var (
 	fullTableColumns = []table.Column{
+		{Title: "♥", Width: 1},
 		{Title: "ID", Width: 7},
-		{Title: "Artist", Width: 22},
+		{Title: "Artist", Width: 20},
 		{Title: "Score", Width: 6},
 	}
 	miniTableColumns = []table.Column{
+		{Title: "♥", Width: 1},
 		{Title: "ID", Width: 7},
 		{Title: "Score", Width: 6},
 	}
@@ -198,53 +287,74 @@ var (
 
 // --- Commands ---
 
+type copyToClipboardMsg struct{ text string }
+
+// copyToClipboardCmd copies text to the clipboard of the user's terminal.
 func copyToClipboardCmd(text string) tea.Cmd {
 	return func() tea.Msg {
-		encodedText := base64.StdEncoding.EncodeToString([]byte(text))
-		fmt.Printf("\x1b]52;c;%s\x07", encodedText)
-		return nil
+		return copyToClipboardMsg{text}
 	}
 }
 
+// copyToClipboard sends text to the terminal with OSC 52.
+func (m *model) copyToClipboard(text string) error {
+	if m.output == nil {
+		return errors.New("no terminal to copy to")
+	}
+	encodedText := base64.StdEncoding.EncodeToString([]byte(text))
+	_, err := fmt.Fprintf(m.output, "\x1b]52;c;%s\x07", encodedText)
+	return err
+}
+
 func clearStatusCmd(d time.Duration) tea.Cmd {
 	return tea.Tick(d, func(t time.Time) tea.Msg {
 		return clearStatusMsg{}
 	})
 }
 
+func animationTickCmd(ctx context.Context, d time.Duration) tea.Cmd {
+	return tea.Tick(d, func(t time.Time) tea.Msg {
+		return animationTickMsg{ctx: ctx}
+	})
+}
+
 func (m *model) fetchPostsCmd() tea.Cmd {
+	return m.fetchPostsAttemptCmd(0)
+}
+
+// fetchPostsAttemptCmd fetches the current page. When the API is rate
+// limiting us or having trouble, it asks the UI to retry later instead of
+// failing.
+func (m *model) fetchPostsAttemptCmd(attempt int) tea.Cmd {
 	return func() tea.Msg {
-		filteredQuery := m.query
+		filteredQuery := m.searchTags()
 
 		log.Printf("Fetching posts for final query: '%s'", filteredQuery)
 
-		req, err := http.NewRequest("GET", apiEndpoint, nil)
+		req, err := m.postsRequest()
 		if err != nil {
 			log.Printf("Error creating request: %v", err)
 			return errorMsg{err}
 		}
-		q := req.URL.Query()
-		q.Add("tags", filteredQuery)
-		q.Add("page", strconv.Itoa(m.currentPage))
-		q.Add("limit", "75")
-		req.URL.RawQuery = q.Encode()
-		req.Header.Set("User-Agent", userAgent)
 
-		resp, err := m.httpClient.Do(req)
+		body, err := upstreamCache.get(context.Background(), m.postsCacheKey(req), m.postsCacheKind(), func(ctx context.Context) ([]byte, error) {
+			return fetchAPI(ctx, m.httpClient, req)
+		})
+		if delay, ok := retryDelay(err, attempt); ok && attempt < maxRetries {
+			log.Printf("Fetching posts failed (attempt %d), retrying in %s: %v", attempt+1, delay, err)
+			return retryPostsMsg{attempt: attempt + 1, delay: delay, err: err}
+		}
 		if err != nil {
+			var statusErr *statusError
+			if errors.As(err, &statusErr) {
+				err = fmt.Errorf("API request failed with %w", statusErr)
+			}
 			log.Printf("Error performing request: %v", err)
 			return errorMsg{err}
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			err := fmt.Errorf("API request failed with status %s: %s", resp.Status, string(body))
-			log.Printf("%v", err)
-			return errorMsg{err}
-		}
 		var postResp PostResponse
-		if err := json.NewDecoder(resp.Body).Decode(&postResp); err != nil {
+		if err := json.Unmarshal(body, &postResp); err != nil {
 			log.Printf("Error decoding JSON response: %v", err)
 			return errorMsg{err}
 		}
@@ -253,6 +363,39 @@ func (m *model) fetchPostsCmd() tea.Cmd {
 	}
 }
 
+// postsRequest builds the request for the current page of posts.
+func (m *model) postsRequest() (*http.Request, error) {
+	req, err := http.NewRequest("GET", m.apiBase()+"/posts.json", nil)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	q.Add("tags", m.searchTags())
+	q.Add("page", strconv.Itoa(m.currentPage))
+	q.Add("limit", "75")
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("User-Agent", userAgent)
+	return req, nil
+}
+
+// postsCacheKey returns the key req is cached under. Responses include
+// whether the user favorited a post, so they can't be shared between users.
+func (m *model) postsCacheKey(req *http.Request) string {
+	if m.account != nil {
+		return "posts:" + m.account.Name + ":" + req.URL.String()
+	}
+	return "posts:" + req.URL.String()
+}
+
+// postsCacheKind returns how long posts are cached and where. A logged in
+// user's posts are never written to disk.
+func (m *model) postsCacheKind() cacheKind {
+	if m.account != nil {
+		return cacheUserPosts
+	}
+	return cachePosts
+}
+
 func (m *model) getDisplayURL(p Post) string {
 	if m.showFullImage {
 		return p.File.URL
@@ -263,76 +406,141 @@ func (m *model) getDisplayURL(p Post) string {
 	return p.File.URL
 }
 
-func downloadAndRenderImage(ctx context.Context, client *http.Client, imageURL string, w, h, xOffset, yOffset int) tea.Cmd {
+func downloadAndRenderImage(ctx context.Context, client *http.Client, cache *previewCache, imageURL string, proto imageProtocol, cell cellSize, w, h, xOffset, yOffset int) tea.Cmd {
 	return func() tea.Msg {
+		if proto == protocolNone {
+			return previewLoadedMsg{content: "\n\nImage previews are disabled.\n\nPress i to pick an image mode."}
+		}
 		if imageURL == "" {
 			return previewLoadedMsg{content: "No image URL available."}
 		}
 
-		tmpfile, err := downloadToTempFile(ctx, client, imageURL)
-		if err != nil {
-			if errors.Is(err, context.Canceled) {
-				// Disregard. We no longer wish to display.
-				return nil
+		box := previewBox(w, h, xOffset, yOffset)
+		key := previewCacheKey(imageURL, proto, box, cell)
+		preview, ok := cache.get(key)
+		if !ok {
+			var err error
+			preview, err = renderPreview(ctx, client, imageURL, proto, box, cell)
+			if err != nil {
+				if ctx.Err() != nil {
+					// Disregard. We no longer wish to display.
+					return nil
+				}
+				log.Printf("Failed to render preview %s: %v", imageURL, err)
+				if errors.Is(err, errNoFFmpeg) {
+					return previewLoadedMsg{content: "\n\n⚠️\n\nVideo previews are not available on this server"}
+				}
+				if errors.Is(err, errTooLarge) {
+					return previewLoadedMsg{content: "\n\n⚠️\n\nThis file is too large to preview"}
+				}
+				return previewLoadedMsg{content: "\n\n⚠️\n\nPreview failed to load"}
 			}
-			log.Printf("Failed to download image: %v", err)
-			return previewLoadedMsg{content: "\n\n⚠️\n\nPreview failed to load"}
+			cache.put(key, preview)
+		}
+		if ctx.Err() != nil {
+			return nil
 		}
-		defer os.Remove(tmpfile.Name())
-
-		paddedWidth := max(w-2, 0)
-		paddedHeight := max(h-2, 0)
-		lOffset := xOffset + 2
-		vOffset := yOffset
 
-		placeArg := fmt.Sprintf("--place=%dx%d@%dx%d", paddedWidth, paddedHeight, lOffset, vOffset)
-		allArgs := []string{"+kitten", "icat", "-z", "-5", "--align=center", "--scale-up", "--stdin=no", placeArg, tmpfile.Name()}
-		cmd := exec.CommandContext(ctx, "kitty", allArgs...)
+		if preview.anim != nil {
+			return animationLoadedMsg{ctx: ctx, anim: preview.anim}
+		}
+		return previewLoadedMsg{content: preview.content}
+	}
+}
 
-		img, err := cmd.CombinedOutput()
+// prefetchPreviewCmd renders a preview into the cache without displaying it.
+func prefetchPreviewCmd(ctx context.Context, client *http.Client, cache *previewCache, imageURL string, proto imageProtocol, cell cellSize, w, h, xOffset, yOffset int) tea.Cmd {
+	box := previewBox(w, h, xOffset, yOffset)
+	key := previewCacheKey(imageURL, proto, box, cell)
+	if !cache.claim(key) {
+		return nil
+	}
+	return func() tea.Msg {
+		defer cache.release(key)
+		preview, err := renderPreview(ctx, client, imageURL, proto, box, cell)
 		if err != nil {
-			if ctx.Err() == context.Canceled {
-				return nil
+			if ctx.Err() == nil {
+				log.Printf("Failed to prefetch preview %s: %v", imageURL, err)
 			}
-			log.Printf("Failed to run command '%s': %s", cmd.String(), string(img))
-			return previewLoadedMsg{content: "\n\n⚠️\n\nPreview failed to load"}
+			return nil
 		}
+		cache.put(key, preview)
+		return nil
+	}
+}
 
-		ansiEscapedOutput := fmt.Sprintf("\x1b[s%s\x1b[u", string(img))
-		return previewLoadedMsg{content: ansiEscapedOutput}
+// previewBox returns the area of the preview pane images are drawn in.
+func previewBox(w, h, xOffset, yOffset int) placement {
+	return placement{
+		cols: max(w-2, 0),
+		rows: max(h-2, 0),
+		x:    xOffset + 2,
+		y:    yOffset,
 	}
 }
 
-func downloadToTempFile(ctx context.Context, client *http.Client, url string) (*os.File, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+// renderPreview downloads an image, animation or video and encodes it for
+// the preview pane.
+func renderPreview(ctx context.Context, client *http.Client, imageURL string, proto imageProtocol, box placement, cell cellSize) (*renderedPreview, error) {
+	data, err := downloadImage(ctx, client, imageURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create image request: %w", err)
+		return nil, err
 	}
-	req.Header.Set("User-Agent", userAgent)
 
-	resp, err := client.Do(req)
+	var frames []animationFrame
+	if ext := strings.ToLower(path.Ext(imageURL)); isVideo(ext) {
+		frames, err = decodeVideoFrames(ctx, data, ext, box.cols*cell.w, box.rows*cell.h)
+	} else {
+		frames, err = decodeFrames(data, box.cols*cell.w, box.rows*cell.h)
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download image, status: %s", resp.Status)
+	if len(frames) > 1 {
+		anim, err := renderAnimation(frames, proto, box, cell)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode animation: %w", err)
+		}
+		return &renderedPreview{anim: anim}, nil
 	}
 
-	tmpfile, err := os.CreateTemp("", "tmp-*.png")
+	out, err := renderImage(frames[0].img, proto, box, cell)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file: %w", err)
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	if !proto.graphical() {
+		return &renderedPreview{content: out}, nil
+	}
+	if proto == protocolKitty {
+		out = kittyDeleteAll() + out
 	}
+	return &renderedPreview{content: fmt.Sprintf("\x1b[s%s\x1b[u", out)}, nil
+}
 
-	_, err = io.Copy(tmpfile, resp.Body)
+func downloadImage(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", imageURL(url), nil)
 	if err != nil {
-		tmpfile.Close()
-		os.Remove(tmpfile.Name())
-		return nil, fmt.Errorf("failed to save image to temp file: %w", err)
+		return nil, fmt.Errorf("failed to create image request: %w", err)
 	}
-	tmpfile.Close()
-	return tmpfile, nil
+	req.Header.Set("User-Agent", userAgent)
+
+	return upstreamCache.get(ctx, url, cacheImages, func(ctx context.Context) ([]byte, error) {
+		for attempt := 0; ; attempt++ {
+			data, err := fetchBody(ctx, client, req, maxImageSize)
+			if err == nil {
+				return data, nil
+			}
+			delay, ok := retryDelay(err, attempt)
+			if !ok || attempt >= maxImageRetries {
+				return nil, fmt.Errorf("failed to download image: %w", err)
+			}
+			log.Printf("Downloading %s failed, retrying in %s: %v", url, delay, err)
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
+	})
 }
 
 func initialModel() model {
@@ -367,37 +575,38 @@ func initialModel() model {
 	postTable.SetStyles(st)
 
 	vp := viewport.New(0, 0)
-	tagVp := viewport.New(0, 0)
 
 	return model{
-		httpClient:       &http.Client{Timeout: 30 * time.Second},
+		httpClient:       upstreamClient,
+		login:            newLoginForm(),
+		historyIndex:     -1,
 		searchBox:        ti,
 		spinner:          s,
 		postTable:        postTable,
-		tagViewport:      tagVp,
 		previewViewport:  vp,
+		cellSize:         defaultCellSize,
+		previewCache:     newPreviewCache(previewCacheSize),
+		prefetchCtx:      context.Background(),
+		thumbnails:       map[int]image.Image{},
+		thumbnailTiles:   map[int]string{},
+		votes:            map[int]int{},
+		safeMode:         serverSafeMode,
 		showFullImage:    false,
 		onEntranceScreen: true,
 		selectedButton:   0, // Default to "Latest"
 		quitting:         false,
 		showTags:         false,
-		currentTags:      "",
 		currentPage:      1,
-		jumpToPostID:     0,
 	}
 }
 
 func (m model) Init() tea.Cmd {
 	log.Println("Model Init() called.")
-	initialCmd := func() tea.Msg {
-		log.Println("Executing initial command: checking for kitty...")
-		if _, err := exec.LookPath("kitty"); err != nil {
-			log.Printf("CRITICAL: 'kitty' command not found in PATH: %v", err)
-			return errorMsg{fmt.Errorf("'kitty' command not found in your PATH. Required for image previews")}
-		}
-		return nil
+	cmds := []tea.Cmd{tea.ClearScreen, textinput.Blink}
+	if m.credentials != nil {
+		cmds = append(cmds, restoreLoginCmd(m.apiBase(), *m.credentials))
 	}
-	return tea.Batch(tea.ClearScreen, textinput.Blink, initialCmd)
+	return tea.Batch(cmds...)
 }
 
 func (m *model) triggerPreviewUpdate() tea.Cmd {
@@ -406,28 +615,54 @@ func (m *model) triggerPreviewUpdate() tea.Cmd {
 	}
 	selectedPost := m.posts[m.postTable.Cursor()]
 
-	// Combine all tags into a single string
-	var allTags []string
-	allTags = append(allTags, selectedPost.Tags.General...)
-	allTags = append(allTags, selectedPost.Tags.Species...)
-	allTags = append(allTags, selectedPost.Tags.Character...)
-	allTags = append(allTags, selectedPost.Tags.Copyright...)
-	allTags = append(allTags, selectedPost.Tags.Artist...)
-	m.currentTags = strings.Join(allTags, ", ")
+	m.setTagList(selectedPost)
 
 	if m.cancelPreview != nil {
 		m.cancelPreview()
 	}
+	m.animation = nil
 	var ctx context.Context
 	ctx, m.cancelPreview = context.WithCancel(context.Background())
 
+	if m.isBlacklisted(selectedPost) {
+		content := fmt.Sprintf("\nPost #%d is blacklisted. Press b to edit your blacklist.", selectedPost.ID)
+		if m.imageProtocol == protocolKitty {
+			content = kittyDeleteAll() + content
+		}
+		m.previewViewport.SetContent(content)
+		return tea.ClearScreen
+	}
+
 	m.previewViewport.SetContent(m.spinner.View() + " Loading preview...")
 	topBarHeight := lipgloss.Height(m.topBarView())
 	previewPaneWidth := m.width * 3 / 4
 	contentHeight := m.height - topBarHeight - lipgloss.Height(m.statusBarView())
 	displayURL := m.getDisplayURL(selectedPost)
 
-	return tea.Batch(tea.ClearScreen, m.spinner.Tick, downloadAndRenderImage(ctx, m.httpClient, displayURL, previewPaneWidth, contentHeight, 0, topBarHeight))
+	cmds := []tea.Cmd{
+		tea.ClearScreen,
+		m.spinner.Tick,
+		downloadAndRenderImage(ctx, m.httpClient, m.previewCache, displayURL, m.imageProtocol, m.cellSize, previewPaneWidth, contentHeight, 0, topBarHeight),
+	}
+
+	// Render the neighbouring posts in the background so moving the cursor
+	// feels instant. Videos are skipped as they're expensive to decode.
+	if m.imageProtocol != protocolNone {
+		cursor := m.postTable.Cursor()
+		for d := 1; d <= prefetchDistance; d++ {
+			for _, i := range []int{cursor + d, cursor - d} {
+				if i < 0 || i >= len(m.posts) {
+					continue
+				}
+				url := m.getDisplayURL(m.posts[i])
+				if url == "" || isVideo(strings.ToLower(path.Ext(url))) || m.isBlacklisted(m.posts[i]) {
+					continue
+				}
+				cmds = append(cmds, prefetchPreviewCmd(m.prefetchCtx, m.httpClient, m.previewCache, url, m.imageProtocol, m.cellSize, previewPaneWidth, contentHeight, 0, topBarHeight))
+			}
+		}
+	}
+	return tea.Batch(cmds...)
 }
 
 // updateEntrance handles logic for the new splash screen.
@@ -440,7 +675,14 @@ func (m *model) updateEntrance(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.width, m.height = msg.Width, msg.Height
 		return m, nil
 
+	case clearStatusMsg:
+		m.statusMessage = ""
+		return m, nil
+
 	case tea.KeyMsg:
+		if m.login.active {
+			return m, m.updateLogin(msg)
+		}
 		if m.quitting {
 			switch msg.String() {
 			case "y", "Y":
@@ -452,17 +694,33 @@ func (m *model) updateEntrance(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		if m.searchBox.Focused() {
+			if m.historySearch.active {
+				return m, m.updateHistorySearch(msg)
+			}
+			if m.autocompleteKey(msg) {
+				return m, nil
+			}
+			if cmd, ok := m.historyKey(msg); ok {
+				return m, cmd
+			}
 			switch msg.String() {
 			case "enter":
+				if err := m.checkQuery(m.searchBox.Value()); err != nil {
+					m.statusMessage = err.Error()
+					return m, clearStatusCmd(3 * time.Second)
+				}
 				m.query = m.searchBox.Value()
+				m.addToHistory(m.query)
 				m.currentPage = 1
 				m.onEntranceScreen = false
 				m.loading = true
 				m.searchBox.Blur()
+				m.clearAutocomplete()
 				cmds = append(cmds, m.fetchPostsCmd(), m.spinner.Tick)
 				return m, tea.Batch(cmds...)
 			case "tab":
 				m.searchBox.Blur()
+				m.clearAutocomplete()
 				return m, nil
 			case "esc", "ctrl+c":
 				m.quitting = true
@@ -472,13 +730,20 @@ func (m *model) updateEntrance(msg tea.Msg) (tea.Model, tea.Cmd) {
 		} else { // Logic for when the buttons are "focused"
 			switch msg.String() {
 			case "enter":
-				if m.selectedButton == 0 {
-					m.searchBox.SetValue("")
-					m.query = ""
+				if saved, ok := m.savedSearchAt(m.selectedButton); ok {
+					m.query = saved.Query
 				} else {
-					m.searchBox.SetValue("order:rank")
-					m.query = "order:rank"
+					switch m.menuPresets()[m.selectedButton] {
+					case "Latest":
+						m.query = ""
+					case "Popular":
+						m.query = "order:rank"
+					case "My favorites":
+						m.query = "fav:" + m.account.Name
+					}
 				}
+				m.searchBox.SetValue(m.query)
+				m.currentPage = 1
 				m.onEntranceScreen = false
 				m.loading = true
 				cmds = append(cmds, m.fetchPostsCmd(), m.spinner.Tick)
@@ -487,11 +752,26 @@ func (m *model) updateEntrance(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.searchBox.Focus()
 				return m, textinput.Blink
 			case "left", "h":
-				m.selectedButton = 0
+				m.selectedButton = max(m.selectedButton-1, 0)
 				return m, nil
 			case "right", "l":
-				m.selectedButton = 1
+				m.selectedButton = min(m.selectedButton+1, len(m.menuButtons())-1)
+				return m, nil
+			case "s":
+				m.toggleSafeMode()
+				if m.statusMessage != "" {
+					return m, clearStatusCmd(2 * time.Second)
+				}
+				return m, nil
+			case "d":
+				m.deleteSavedSearch(m.selectedButton)
 				return m, nil
+			case "a":
+				if m.account != nil {
+					m.logout()
+					return m, nil
+				}
+				return m, m.openLogin()
 			case "q", "esc", "ctrl+c":
 				m.quitting = true
 				return m, nil
@@ -499,8 +779,11 @@ func (m *model) updateEntrance(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 	}
 
+	if m.login.active {
+		cmds = append(cmds, m.login.updateInputs(msg))
+	}
 	m.searchBox, cmd = m.searchBox.Update(msg)
-	cmds = append(cmds, cmd)
+	cmds = append(cmds, cmd, m.scheduleAutocomplete())
 	return m, tea.Batch(cmds...)
 }
 
@@ -508,84 +791,184 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	var cmds []tea.Cmd
 
+	// Logins are checked in the background when restored from the settings,
+	// so the result may arrive on any screen.
+	if msg, ok := msg.(loginResultMsg); ok {
+		m.finishLogin(msg)
+		return m, nil
+	}
+	if cmd, ok := m.updateAutocomplete(msg); ok {
+		return m, cmd
+	}
+
 	if m.onEntranceScreen {
 		return m.updateEntrance(msg)
 	}
 
-	// If tag view is active, handle its specific keybindings and updates.
+	// If tag view is active, it takes all keys.
 	if m.showTags {
 		if keyMsg, ok := msg.(tea.KeyMsg); ok {
-			switch {
-			case key.Matches(keyMsg, key.NewBinding(key.WithKeys("t", "esc"))):
-				m.showTags = false
-				return m, nil
-			}
+			return m, m.updateTagList(keyMsg)
 		}
+	}
+
+	// So does the details pane.
+	if m.showDetails {
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			return m, m.updateDetails(keyMsg)
+		}
+	}
+
+	// And the comments.
+	if m.comments.active {
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			return m, m.updateComments(keyMsg)
+		}
+		if m.comments.replying {
+			m.comments.reply, cmd = m.comments.reply.Update(msg)
+			cmds = append(cmds, cmd)
+		}
+	}
 
-		// Pass other messages to the tag viewport for scrolling.
-		m.tagViewport, cmd = m.tagViewport.Update(msg)
+	// And the pool reader.
+	if m.pool.active {
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			return m, m.updatePool(keyMsg)
+		}
+	}
+
+	// The blacklist editor takes all keys while it's open.
+	if m.editingBlacklist {
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			return m, m.updateBlacklistEditor(keyMsg)
+		}
+		m.blacklistEditor, cmd = m.blacklistEditor.Update(msg)
+		cmds = append(cmds, cmd)
+	}
+
+	// So does naming a saved search.
+	if m.savingSearch {
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			return m, m.updateSaveSearch(keyMsg)
+		}
+		m.searchNameInput, cmd = m.searchNameInput.Update(msg)
 		cmds = append(cmds, cmd)
-		return m, tea.Batch(cmds...)
 	}
 
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		m.width, m.height = msg.Width-2, msg.Height
+		if m.gridMode && len(m.posts) > 0 {
+			m.scrollGridToCursor()
+			m.renderGridTiles()
+			cmds = append(cmds, m.fetchVisibleThumbnailsCmd())
+		}
 
 	case postsFetchedMsg:
 		m.loading = false
+		m.retryAt = time.Time{}
 		m.posts = msg.posts
+		// Stop prefetching posts from the previous page.
+		if m.cancelPrefetch != nil {
+			m.cancelPrefetch()
+		}
+		m.prefetchCtx, m.cancelPrefetch = context.WithCancel(context.Background())
 		m.showTags = false // Default to showing posts after a new fetch
+		m.showDetails = false
+		m.updateTableRows()
 
-		isMiniTable := (m.width*1/4 - 4) < 42
-		if isMiniTable {
-			m.postTable.SetColumns(miniTableColumns)
+		if len(m.posts) > 0 && m.gridMode {
+			cmds = append(cmds, m.resetGrid())
+		} else if len(m.posts) > 0 {
+			cmds = append(cmds, m.triggerPreviewUpdate())
 		} else {
-			m.postTable.SetColumns(fullTableColumns)
+			m.previewViewport.SetContent("\nNo results found for your query.")
 		}
 
-		rows := []table.Row{}
-		for _, post := range m.posts {
-			scoreStr := strconv.Itoa(post.Score.Total)
+	case previewLoadedMsg:
+		m.animation = nil
+		m.previewViewport.SetContent(msg.content)
+		m.previewViewport.GotoTop()
 
-			if isMiniTable {
-				rows = append(rows, table.Row{
-					strconv.Itoa(post.ID),
-					scoreStr,
-				})
-			} else {
-				artists := strings.Join(post.Tags.Artist, ", ")
-				if artists == "" {
-					artists = "unknown"
-				}
-				rows = append(rows, table.Row{
-					strconv.Itoa(post.ID),
-					artists,
-					scoreStr,
-				})
-			}
+	case animationLoadedMsg:
+		if msg.ctx.Err() != nil {
+			break
 		}
-		m.postTable.SetRows(rows)
+		m.animation = msg.anim
+		m.animationCtx = msg.ctx
+		m.animationFrame = 0
+		m.previewViewport.SetContent(m.animation.frameContent(0, true))
+		m.previewViewport.GotoTop()
+		cmds = append(cmds, animationTickCmd(msg.ctx, m.animation.delays[0]))
 
-		if m.jumpToPostID != 0 {
-			for i, post := range m.posts {
-				if post.ID == m.jumpToPostID {
-					m.postTable.SetCursor(i)
-					break
-				}
+	case animationTickMsg:
+		// Stop once the preview was cancelled or replaced by another one.
+		if m.animation == nil || msg.ctx != m.animationCtx || msg.ctx.Err() != nil {
+			break
+		}
+		m.animationFrame = (m.animationFrame + 1) % len(m.animation.frames)
+		m.previewViewport.SetContent(m.animation.frameContent(m.animationFrame, false))
+		cmds = append(cmds, animationTickCmd(msg.ctx, m.animation.delays[m.animationFrame]))
+
+	case thumbnailLoadedMsg:
+		if !m.gridMode {
+			break
+		}
+		m.thumbnails[msg.postID] = msg.img
+		for i, post := range m.posts {
+			if post.ID == msg.postID {
+				m.renderGridTile(i)
+				break
 			}
-			m.jumpToPostID = 0 // Reset after use
 		}
 
-		if len(m.posts) > 0 {
-			cmds = append(cmds, m.triggerPreviewUpdate())
-		} else {
-			m.previewViewport.SetContent("\nNo results found for your query.")
+	case retryPostsMsg:
+		m.retryAt = time.Now().Add(msg.delay)
+		m.retryReason = "Request failed"
+		var statusErr *statusError
+		if errors.As(msg.err, &statusErr) && statusErr.code == http.StatusTooManyRequests {
+			m.retryReason = "Rate limited"
+		}
+		attempt := msg.attempt
+		cmds = append(cmds, tea.Tick(msg.delay, func(time.Time) tea.Msg {
+			return retryPostsDueMsg{attempt: attempt}
+		}))
+
+	case retryPostsDueMsg:
+		// The user may have moved on while we were waiting.
+		if m.loading && !m.retryAt.IsZero() {
+			m.retryAt = time.Time{}
+			cmds = append(cmds, m.fetchPostsAttemptCmd(msg.attempt))
 		}
 
-	case previewLoadedMsg:
-		m.previewViewport.SetContent(msg.content)
-		m.previewViewport.GotoTop()
+	case favoriteToggledMsg:
+		cmds = append(cmds, m.applyFavorite(msg))
+
+	case votedMsg:
+		cmds = append(cmds, m.applyVote(msg))
+
+	case commentsFetchedMsg:
+		m.applyComments(msg)
+
+	case commentPostedMsg:
+		cmds = append(cmds, m.applyCommentPosted(msg))
+
+	case poolChoicesMsg:
+		m.applyPoolChoices(msg)
+
+	case poolFetchedMsg:
+		cmds = append(cmds, m.applyPool(msg))
+
+	case poolPostsMsg:
+		cmds = append(cmds, m.applyPoolPosts(msg))
+
+	case blacklistSyncedMsg:
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Couldn't save the blacklist to e621: %v", msg.err)
+		} else {
+			m.statusMessage = "Blacklist saved to your e621 account"
+		}
+		cmds = append(cmds, clearStatusCmd(3*time.Second))
 
 	case errorMsg:
 		m.err = msg.err
@@ -593,8 +976,15 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case clearStatusMsg:
 		m.statusMessage = ""
 
+	case copyToClipboardMsg:
+		if err := m.copyToClipboard(msg.text); err != nil {
+			log.Printf("Failed to copy to clipboard: %v", err)
+			m.statusMessage = "Couldn't copy to the clipboard"
+			cmds = append(cmds, clearStatusCmd(2*time.Second))
+		}
+
 	case spinner.TickMsg:
-		if m.loading {
+		if m.loading || m.comments.loading || m.comments.sending || m.pool.loading || m.poolPageLoading() {
 			m.spinner, cmd = m.spinner.Update(msg)
 			cmds = append(cmds, cmd)
 		}
@@ -602,28 +992,53 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case tea.KeyMsg:
 		if m.searchBox.Focused() {
+			if m.historySearch.active {
+				return m, m.updateHistorySearch(msg)
+			}
+			if m.autocompleteKey(msg) {
+				return m, nil
+			}
+			if cmd, ok := m.historyKey(msg); ok {
+				return m, cmd
+			}
 			if key.Matches(msg, key.NewBinding(key.WithKeys("enter"))) {
+				if err := m.checkQuery(m.searchBox.Value()); err != nil {
+					m.searchBox.Blur()
+					m.statusMessage = err.Error()
+					return m, clearStatusCmd(3 * time.Second)
+				}
 				m.query = m.searchBox.Value()
+				m.addToHistory(m.query)
 				m.loading = true
 				m.searchBox.Blur()
+				m.clearAutocomplete()
 				m.posts = []Post{}
 				m.postTable.SetRows([]table.Row{})
 				m.previewViewport.SetContent("")
 				cmds = append(cmds, m.fetchPostsCmd(), m.spinner.Tick)
 			} else if key.Matches(msg, key.NewBinding(key.WithKeys("esc"))) {
 				m.searchBox.Blur()
+				m.clearAutocomplete()
 			} else {
 				m.searchBox, cmd = m.searchBox.Update(msg)
-				cmds = append(cmds, cmd)
+				cmds = append(cmds, cmd, m.scheduleAutocomplete())
 			}
 		} else {
+			if m.gridMode {
+				if handled, cmd := m.updateGrid(msg); handled {
+					return m, cmd
+				}
+			}
 			switch {
 			case key.Matches(msg, key.NewBinding(key.WithKeys("q", "esc"))):
+				m.gridMode = false
+				m.stopGrid()
+				m.retryAt = time.Time{}
 				m.onEntranceScreen = true
 				m.posts = []Post{}
 				m.postTable.SetRows([]table.Row{})
 				m.previewViewport.SetContent("")
-				m.searchBox.SetValue(m.query)
+				m.setSearchText(m.query)
 				m.searchBox.Focus()
 				return m, tea.Batch(textinput.Blink, tea.ClearScreen)
 			case key.Matches(msg, key.NewBinding(key.WithKeys("c"))):
@@ -643,50 +1058,53 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				cmds = append(cmds, m.fetchPostsCmd(), m.spinner.Tick, tea.ClearScreen)
 			case key.Matches(msg, key.NewBinding(key.WithKeys("e"))):
 				m.showFullImage = !m.showFullImage
+				m.settings.ShowFullImage = m.showFullImage
+				m.saveSettings()
 				if len(m.posts) > 0 {
 					cmds = append(cmds, m.triggerPreviewUpdate())
 				}
+			case key.Matches(msg, key.NewBinding(key.WithKeys("i"))):
+				m.imageProtocol = m.imageProtocol.next()
+				m.settings.ImageProtocol = m.imageProtocol.String()
+				m.saveSettings()
+				m.statusMessage = fmt.Sprintf("Image mode: %s", m.imageProtocol)
+				cmds = append(cmds, clearStatusCmd(2*time.Second))
+				if m.gridMode {
+					m.renderGridTiles()
+					cmds = append(cmds, tea.ClearScreen)
+				} else if len(m.posts) > 0 {
+					cmds = append(cmds, m.triggerPreviewUpdate())
+				}
+			case key.Matches(msg, key.NewBinding(key.WithKeys("g"))):
+				if !m.loading {
+					cmds = append(cmds, m.toggleGrid())
+				}
+			case key.Matches(msg, key.NewBinding(key.WithKeys("f"))):
+				cmds = append(cmds, m.toggleFavorite())
+			case key.Matches(msg, key.NewBinding(key.WithKeys("+", "="))):
+				cmds = append(cmds, m.vote(1))
+			case key.Matches(msg, key.NewBinding(key.WithKeys("-"))):
+				cmds = append(cmds, m.vote(-1))
+			case key.Matches(msg, key.NewBinding(key.WithKeys("b"))):
+				cmds = append(cmds, m.openBlacklistEditor())
+			case key.Matches(msg, key.NewBinding(key.WithKeys("s"))):
+				cmds = append(cmds, m.openSaveSearch())
 			case key.Matches(msg, key.NewBinding(key.WithKeys("p"))):
 				if !m.loading && len(m.posts) > 0 && m.postTable.Cursor() < len(m.posts) {
-					selectedPost := m.posts[m.postTable.Cursor()]
-					if len(selectedPost.Pools) > 0 {
-						poolID := selectedPost.Pools[0]
-						newQuery := fmt.Sprintf("pool:%d", poolID)
-						if m.query != newQuery {
-							m.jumpToPostID = selectedPost.ID
-							m.query = newQuery
-							m.searchBox.SetValue(m.query)
-							m.currentPage = 1
-							m.loading = true
-							m.posts = []Post{}
-							m.postTable.SetRows([]table.Row{})
-							m.previewViewport.SetContent("")
-							cmds = append(cmds, m.fetchPostsCmd(), m.spinner.Tick, tea.ClearScreen)
-						}
-					}
+					cmds = append(cmds, m.openPoolOf(m.posts[m.postTable.Cursor()]))
 				}
 			case key.Matches(msg, key.NewBinding(key.WithKeys("t"))):
 				if len(m.posts) > 0 {
 					m.showTags = !m.showTags
 				}
+			case key.Matches(msg, key.NewBinding(key.WithKeys("d"))):
+				m.toggleDetails()
+			case key.Matches(msg, key.NewBinding(key.WithKeys("C"))):
+				cmds = append(cmds, m.openComments())
 			case key.Matches(msg, key.NewBinding(key.WithKeys("h", "left"))):
-				if m.currentPage > 1 && !m.loading {
-					m.currentPage = m.currentPage - 1
-				}
-				m.loading = true
-				m.posts = []Post{}
-				m.postTable.SetRows([]table.Row{})
-				m.previewViewport.SetContent("")
-				cmds = append(cmds, m.fetchPostsCmd(), m.spinner.Tick, tea.ClearScreen)
+				cmds = append(cmds, m.changePage(-1))
 			case key.Matches(msg, key.NewBinding(key.WithKeys("l", "right"))):
-				if m.currentPage < 750 && !m.loading {
-					m.currentPage = m.currentPage + 1
-				}
-				m.loading = true
-				m.posts = []Post{}
-				m.postTable.SetRows([]table.Row{})
-				m.previewViewport.SetContent("")
-				cmds = append(cmds, m.fetchPostsCmd(), m.spinner.Tick, tea.ClearScreen)
+				cmds = append(cmds, m.changePage(1))
 			default:
 				if !m.loading {
 					originalCursor := m.postTable.Cursor()
@@ -705,11 +1123,89 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// updateTableRows fills the post table from m.posts, e.g. after a post was
+// favorited. The cursor stays where it is.
+func (m *model) updateTableRows() {
+	isMiniTable := (m.width*1/4 - 4) < 42
+	if isMiniTable {
+		m.postTable.SetColumns(miniTableColumns)
+	} else {
+		m.postTable.SetColumns(fullTableColumns)
+	}
+
+	rows := []table.Row{}
+	for _, post := range m.posts {
+		scoreStr := m.voteMarker(post.ID) + strconv.Itoa(post.Score.Total)
+		favStr := ""
+		if post.IsFavorited {
+			favStr = "♥"
+		}
+		blacklisted := m.isBlacklisted(post)
+		if blacklisted {
+			favStr = "✕"
+		}
+
+		if isMiniTable {
+			rows = append(rows, table.Row{
+				favStr,
+				strconv.Itoa(post.ID),
+				scoreStr,
+			})
+		} else {
+			artists := sanitizeText(strings.Join(post.Tags.Artist, ", "))
+			if artists == "" {
+				artists = "unknown"
+			}
+			if blacklisted {
+				artists = "[blacklisted]"
+			}
+			rows = append(rows, table.Row{
+				favStr,
+				strconv.Itoa(post.ID),
+				artists,
+				scoreStr,
+			})
+		}
+	}
+	m.postTable.SetRows(rows)
+}
+
+// changePage moves delta pages forward or back and fetches the new page.
+func (m *model) changePage(delta int) tea.Cmd {
+	if page := m.currentPage + delta; page >= 1 && page <= 750 && !m.loading {
+		m.currentPage = page
+	}
+	m.loading = true
+	m.posts = []Post{}
+	m.postTable.SetRows([]table.Row{})
+	m.previewViewport.SetContent("")
+	return tea.Batch(m.fetchPostsCmd(), m.spinner.Tick, tea.ClearScreen)
+}
+
+// menuPresets returns the labels of the built-in searches on the main menu.
+func (m *model) menuPresets() []string {
+	if m.account != nil {
+		return []string{"Latest", "Popular", "My favorites"}
+	}
+	return []string{"Latest", "Popular"}
+}
+
+// menuButtons returns the presets followed by the user's saved searches.
+func (m *model) menuButtons() []string {
+	buttons := m.menuPresets()
+	for _, saved := range m.settings.SavedSearches {
+		buttons = append(buttons, truncate(saved.Name, maxSavedNameLen))
+	}
+	return buttons
+}
+
 func (m *model) menuView() string {
 	var view string
 
 	if m.quitting {
 		view = quitPromptStyle.Render("Are you sure you want to quit? (y/n)")
+	} else if m.login.active {
+		view = lipgloss.JoinVertical(lipgloss.Center, e621shStyle.Render(e621shAscii), m.loginView())
 	} else {
 		asciiArt := e621shStyle.Render(e621shAscii)
 
@@ -719,30 +1215,72 @@ func (m *model) menuView() string {
 		}
 		searchBoxView := currentSearchBoxStyle.Render(m.searchBox.View())
 
-		latestButton := buttonStyle.Render("Latest")
-		popularButton := buttonStyle.Render("Popular")
-		if !m.searchBox.Focused() {
-			if m.selectedButton == 0 {
-				latestButton = selectedButtonStyle.Render("Latest")
+		// Saved searches get a row of their own under the presets.
+		var presets, saved []string
+		for i, label := range m.menuButtons() {
+			row := &presets
+			if i >= len(m.menuPresets()) {
+				row = &saved
+			}
+			if len(*row) > 0 {
+				*row = append(*row, "  ")
+			}
+			if !m.searchBox.Focused() && i == m.selectedButton {
+				*row = append(*row, selectedButtonStyle.Render(label))
 			} else {
-				popularButton = selectedButtonStyle.Render("Popular")
+				*row = append(*row, buttonStyle.Render(label))
 			}
 		}
-		buttonsView := lipgloss.JoinHorizontal(lipgloss.Top, latestButton, "  ", popularButton)
+		buttonsView := lipgloss.JoinHorizontal(lipgloss.Top, presets...)
+		if len(saved) > 0 {
+			buttonsView = lipgloss.JoinVertical(lipgloss.Center, buttonsView, lipgloss.JoinHorizontal(lipgloss.Top, saved...))
+		}
 
 		var helpTextContent string
-		if m.searchBox.Focused() {
+		if m.historySearch.active {
+			helpTextContent = "↑/↓: pick search | enter/tab: use | esc: cancel"
+		} else if len(m.autocomplete.suggestions) > 0 && m.searchBox.Focused() {
+			helpTextContent = "↑/↓: pick tag | tab: complete | esc: close suggestions | enter: search"
+		} else if m.searchBox.Focused() && len(m.settings.History) > 0 {
+			helpTextContent = "enter: search | ↑/↓: history | ctrl+r: search history | tab: select buttons | esc: quit"
+		} else if m.searchBox.Focused() {
 			helpTextContent = "enter: search | tab: select buttons | esc: quit"
 		} else {
-			helpTextContent = "←/→: nav | enter: select | tab: edit search | esc: quit"
+			helpTextContent = "←/→: nav | enter: select | tab: edit search"
+			if _, ok := m.savedSearchAt(m.selectedButton); ok {
+				helpTextContent += " | d: delete"
+			}
+			if m.account != nil {
+				helpTextContent += " | a: log out"
+			} else {
+				helpTextContent += " | a: log in"
+			}
+			helpTextContent += " | s: safe mode | esc: quit"
 		}
 		helpView := helpStyle.Render(helpTextContent)
 
+		accountText := "Not logged in"
+		if m.account != nil {
+			accountText = "Logged in as " + m.account.Name
+		}
+		if m.safeMode {
+			accountText += " | Safe mode (e926)"
+		}
+		accountView := helpStyle.Render(accountText)
+		if m.statusMessage != "" {
+			accountView = lipgloss.NewStyle().Foreground(errorColor).Render(sanitizeText(m.statusMessage))
+		}
+
+		if suggestions := m.searchDropdownView(lipgloss.Width(searchBoxView)); suggestions != "" {
+			searchBoxView = lipgloss.JoinVertical(lipgloss.Left, searchBoxView, suggestions)
+		}
+
 		view = lipgloss.JoinVertical(
 			lipgloss.Center,
 			asciiArt,
 			searchBoxView,
 			buttonsView,
+			accountView,
 			helpView,
 		)
 	}
@@ -756,85 +1294,67 @@ func (m *model) menuView() string {
 	)
 }
 
+// contentHeight returns the height left between the top and status bars.
+func (m *model) contentHeight() int {
+	return m.height - lipgloss.Height(m.topBarView()) - lipgloss.Height(m.statusBarView())
+}
+
 func (m *model) topBarView() string {
 	spacer := "\n\n"
 	topBarText := fmt.Sprintf("Query: %s", m.query)
-	return spacer + topBarStyle.Width(m.width).Render(topBarText)
-}
-
-func (m *model) styledQueryText() string {
-	val := m.searchBox.Value()
-	cursorPos := m.searchBox.Position()
-	cursorStyle := m.searchBox.Cursor.Style
-	matches := filterRegex.FindAllStringSubmatchIndex(val, -1)
-	styleMap := make(map[int]lipgloss.Style)
-	keyIdx := filterRegex.SubexpIndex("key")
-	valueIdx := filterRegex.SubexpIndex("value")
-
-	for _, match := range matches {
-		if keyIdx != -1 {
-			for i := match[2*keyIdx]; i < match[2*keyIdx+1]; i++ {
-				styleMap[i] = keyStyle
-			}
-		}
-		if valueIdx != -1 {
-			for i := match[2*valueIdx]; i < match[2*valueIdx+1]; i++ {
-				styleMap[i] = valueStyle
-			}
-		}
-	}
-
-	var styledParts []string
-	currentByte := 0
-	for _, r := range val {
-		char := string(r)
-		style, ok := styleMap[currentByte]
-		if !ok {
-			style = defaultTextStyle
-		}
-
-		if m.searchBox.Cursor.Blink && currentByte == cursorPos {
-			char = m.searchBox.Cursor.View()
-		} else if currentByte == cursorPos {
-			char = cursorStyle.Render(char)
-		}
-		styledParts = append(styledParts, style.Render(char))
-		currentByte += len(char)
+	if m.safeMode {
+		topBarText = safeModeStyle.Render("SAFE MODE") + " " + topBarText
 	}
-
-	if cursorPos == len(val) && m.searchBox.Cursor.Blink {
-		styledParts = append(styledParts, m.searchBox.Cursor.View())
-	} else if cursorPos == len(val) {
-		styledParts = append(styledParts, cursorStyle.Render(" "))
-	}
-	return lipgloss.JoinHorizontal(lipgloss.Top, styledParts...)
+	return spacer + topBarStyle.Width(m.width).Render(topBarText)
 }
 
 func (m *model) statusBarView() string {
 	var statusText string
 	if m.showTags {
-		statusText = "t/esc: close tags popup"
+		statusText = m.tagListHelp()
+	} else if m.pool.active && m.statusMessage == "" {
+		statusText = m.poolHelp()
+	} else if m.comments.active && m.statusMessage == "" {
+		statusText = m.commentsHelp()
+	} else if m.showDetails && m.statusMessage == "" {
+		statusText = "↑/↓: scroll | tab: select link | enter: follow link | s: show spoilers | d/esc: close details"
+	} else if m.editingBlacklist {
+		statusText = "ctrl+s: save blacklist | esc: cancel"
+	} else if m.savingSearch {
+		statusText = m.searchNameInput.View() + "  " + helpStyle.Render("enter: save | esc: cancel")
+	} else if m.gridMode && !m.searchBox.Focused() && m.statusMessage == "" {
+		statusText = fmt.Sprintf("hjkl: move | enter: open | [/]: page %d | /: filter | s: save search | r: refresh | i: image mode (%s) | g: list view", m.currentPage, m.imageProtocol)
+		if m.account != nil {
+			statusText += " | f: favorite | +/-: vote"
+		}
+		statusText += " | esc: back to menu"
 	} else if m.searchBox.Focused() {
 		statusText = "Filter: " + m.styledQueryText()
 	} else if m.statusMessage != "" {
-		statusText = m.statusMessage
+		statusText = sanitizeText(m.statusMessage)
 	} else {
 		imageModeText := "full/[sample]"
 		if m.showFullImage {
 			imageModeText = "[full]/sample"
 		}
-		statusText = fmt.Sprintf("↑/↓: nav | c: copy url | /: filter | r: refresh | e: %s | t: show tags popup", imageModeText)
+		statusText = fmt.Sprintf("↑/↓: nav | c: copy url | /: filter | s: save search | r: refresh | e: %s | i: image mode (%s) | g: grid | t: show tags popup | d: details | C: comments", imageModeText, m.imageProtocol)
+		if m.account != nil {
+			statusText += " | f: favorite | +/-: vote"
+		}
 
 		if !m.loading && len(m.posts) > 0 && m.postTable.Cursor() < len(m.posts) {
 			selectedPost := m.posts[m.postTable.Cursor()]
 			if len(selectedPost.Pools) > 0 {
-				statusText += " | p: view pool"
+				statusText += " | p: read pool"
 			}
 		}
 
 		statusText += " | esc: back to menu"
 	}
 	statusBar.Width(m.width)
+	if suggestions := m.searchDropdownView(min(m.width, 60)); suggestions != "" {
+		return lipgloss.JoinVertical(lipgloss.Left, suggestions, statusBar.Render(statusText))
+	}
 	return statusBar.Render(statusText)
 }
 
@@ -847,11 +1367,15 @@ func (m model) View() string {
 	if m.onEntranceScreen {
 		finalView = m.menuView()
 	} else if m.err != nil {
-		errText := fmt.Sprintf("An error occurred:\n\n%s\n\nPress Esc to quit.", m.err.Error())
+		errText := fmt.Sprintf("An error occurred:\n\n%s\n\nPress Esc to quit.", sanitizeText(m.err.Error()))
 		ui := lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, errorBoxStyle.Render(errText))
 		finalView = ui
 	} else if m.loading {
 		text := fmt.Sprintf("\n   %s Fetching data for '%s'...\n", m.spinner.View(), m.query)
+		if !m.retryAt.IsZero() {
+			wait := max(time.Until(m.retryAt).Round(time.Second), 0)
+			text += helpStyle.Render(fmt.Sprintf("\n   %s, retrying in %s\n", m.retryReason, wait))
+		}
 		ui := lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, text)
 		finalView = ui
 	} else {
@@ -859,6 +1383,30 @@ func (m model) View() string {
 		statusBarView := m.statusBarView()
 		contentHeight := m.height - lipgloss.Height(topBarView) - lipgloss.Height(statusBarView)
 
+		if m.pool.active {
+			mainView := m.poolView(contentHeight)
+			finalView = lipgloss.JoinVertical(lipgloss.Left, topBarView, mainView, statusBarView)
+			return appStyle.Render(finalView)
+		}
+
+		if m.comments.active {
+			mainView := m.commentsPaneView(contentHeight)
+			finalView = lipgloss.JoinVertical(lipgloss.Left, topBarView, mainView, statusBarView)
+			return appStyle.Render(finalView)
+		}
+
+		if m.editingBlacklist {
+			mainView := m.blacklistView(contentHeight)
+			finalView = lipgloss.JoinVertical(lipgloss.Left, topBarView, mainView, statusBarView)
+			return appStyle.Render(finalView)
+		}
+
+		if m.gridMode {
+			mainView := m.gridView(contentHeight)
+			finalView = lipgloss.JoinVertical(lipgloss.Left, topBarView, mainView, statusBarView)
+			return appStyle.Render(finalView)
+		}
+
 		previewPaneWidth := m.width * 3 / 4
 		sidePaneWidth := m.width - previewPaneWidth - 4
 
@@ -867,8 +1415,6 @@ func (m model) View() string {
 
 		m.postTable.SetWidth(sidePaneWidth - 2)
 		m.postTable.SetHeight(contentHeight - 2)
-		m.tagViewport.Width = sidePaneWidth - 2
-		m.tagViewport.Height = contentHeight - 2
 
 		previewPane := previewPaneStyle.
 			Width(previewPaneWidth).
@@ -876,15 +1422,16 @@ func (m model) View() string {
 			Render(m.previewViewport.View())
 
 		var sidePaneView string
-		if m.showTags {
-			wrappedContent := lipgloss.NewStyle().
-				Width(m.tagViewport.Width).
-				Render("Tags:\n\n" + m.currentTags)
-			m.tagViewport.SetContent(wrappedContent)
+		if m.showDetails {
 			sidePaneView = paneStyle.
 				Width(sidePaneWidth).
 				Height(contentHeight).
-				Render(m.tagViewport.View())
+				Render(m.detailsView(sidePaneWidth-2, contentHeight-2))
+		} else if m.showTags {
+			sidePaneView = paneStyle.
+				Width(sidePaneWidth).
+				Height(contentHeight).
+				Render("Tags:\n\n" + m.tagListView(sidePaneWidth-2, contentHeight-4))
 		} else {
 			sidePaneView = paneStyle.
 				Width(sidePaneWidth).
@@ -923,6 +1470,14 @@ func main() {
 
 	log.Println("--------------------")
 	log.Println("Logger initialized. Starting server...")
+	if err := setupConfig(os.Args[1:]); errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	setupCache()
+	setupSettings()
+	setupSafeMode()
 
 	port := os.Getenv("E6TEA_PORT")
 	if port == "" {
@@ -932,8 +1487,12 @@ func main() {
 	s, err := wish.NewServer(
 		wish.WithAddress(fmt.Sprintf("%s:%s", host, port)),
 		wish.WithHostKeyPath(".ssh/term_info_ed25519"),
+		// Anyone may connect. Public keys are only used to recognise
+		// returning users, others get in with keyboard-interactive auth.
+		wish.WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool { return true }),
+		wish.WithKeyboardInteractiveAuth(func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool { return true }),
 		wish.WithMiddleware(
-			bubbletea.Middleware(teaHandler),
+			bubbletea.MiddlewareWithProgramHandler(programHandler, termenv.Ascii),
 			logging.Middleware(),
 		),
 	)
@@ -959,14 +1518,31 @@ func main() {
 	}
 }
 
+// programHandler starts the program with only the options from teaHandler.
+// The middleware's default handler appends the session as input, which would
+// replace the probed input and have two readers race for the client's keys.
+func programHandler(s ssh.Session) *tea.Program {
+	m, opts := teaHandler(s)
+	if m == nil {
+		return nil
+	}
+	return tea.NewProgram(m, opts...)
+}
+
 func teaHandler(s ssh.Session) (tea.Model, []tea.ProgramOption) {
 	pty, _, active := s.Pty()
 	if !active {
 		wish.Fatalln(s, "no active PTY found")
 		return nil, nil
 	}
+	proto, input := negotiateImageProtocol(s, pty.Term)
 	m := initialModel()
 	m.width = pty.Window.Width
 	m.height = pty.Window.Height
-	return m, []tea.ProgramOption{tea.WithInput(s), tea.WithOutput(s), tea.WithAltScreen()}
+	m.imageProtocol = proto
+	output := &sessionOutput{w: s}
+	m.output = output
+	m.cellSize = cellSizeFromWindow(pty.Window.Width, pty.Window.Height, pty.Window.WidthPixels, pty.Window.HeightPixels)
+	m.loadSettings(s)
+	return m, []tea.ProgramOption{tea.WithInput(input), tea.WithOutput(output), tea.WithAltScreen()}
 }
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- Pool Reader ---
+//
+// Pools are read one post at a time in the pool's own order, like the pages
+// of a comic. Posts are fetched in chunks around the page being read.
+
+const (
+	poolChunkSize        = 100 // Posts fetched at once, the most id: takes.
+	poolDescriptionLines = 3
+)
+
+type pool struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Category    string `json:"category"` // "series" or "collection".
+	PostIDs     []int  `json:"post_ids"`
+	PostCount   int    `json:"post_count"`
+	CreatorName string `json:"creator_name"`
+}
+
+// title returns the pool's name as the site shows it.
+func (p pool) title() string {
+	if p.Name == "" {
+		return fmt.Sprintf("Pool #%d", p.ID)
+	}
+	return sanitizeText(strings.ReplaceAll(p.Name, "_", " "))
+}
+
+// poolReader is the state of the pool reader.
+type poolReader struct {
+	active          bool
+	loading         bool
+	err             error
+	pool            pool
+	startPost       int          // Post to open the pool at, 0 for its first page.
+	index           int          // Page being read, an index into pool.PostIDs.
+	posts           map[int]Post // Posts of the pool fetched so far, keyed by ID.
+	chunks          map[int]bool // Chunks of posts requested, true once they arrived.
+	hideDescription bool
+
+	// When the post is in several pools, one is picked first.
+	picking bool
+	choices []pool
+	choice  int
+}
+
+type poolFetchedMsg struct {
+	id   int
+	pool pool
+	err  error
+}
+
+type poolChoicesMsg struct {
+	postID int
+	pools  []pool
+	err    error
+}
+
+type poolPostsMsg struct {
+	poolID int
+	chunk  int
+	posts  []Post
+	err    error
+}
+
+// fetchPools loads pools from url, which answers with one pool or a list.
+func fetchPools(client *http.Client, url string, list bool) ([]pool, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	body, err := upstreamCache.get(context.Background(), "pools:"+req.URL.String(), cachePosts, func(ctx context.Context) ([]byte, error) {
+		return fetchAPI(ctx, client, req)
+	})
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		err = fmt.Errorf("API request failed with %w", statusErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !list {
+		var p pool
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, err
+		}
+		return []pool{p}, nil
+	}
+	var pools []pool
+	if err := json.Unmarshal(body, &pools); err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
+// fetchPoolCmd loads a pool with the IDs of its posts.
+func fetchPoolCmd(client *http.Client, host string, id int) tea.Cmd {
+	return func() tea.Msg {
+		pools, err := fetchPools(client, fmt.Sprintf("%s/pools/%d.json", host, id), false)
+		if err != nil {
+			log.Printf("Failed to fetch pool %d: %v", id, err)
+			return poolFetchedMsg{id: id, err: err}
+		}
+		return poolFetchedMsg{id: id, pool: pools[0]}
+	}
+}
+
+// fetchPoolChoicesCmd loads the pools a post is in, to pick one of them.
+func fetchPoolChoicesCmd(client *http.Client, host string, post Post) tea.Cmd {
+	return func() tea.Msg {
+		ids := make([]string, len(post.Pools))
+		for i, id := range post.Pools {
+			ids[i] = strconv.Itoa(id)
+		}
+		q := url.Values{
+			"search[id]": {strings.Join(ids, ",")},
+			"limit":      {strconv.Itoa(len(ids))},
+		}
+		pools, err := fetchPools(client, host+"/pools.json?"+q.Encode(), true)
+		if err != nil {
+			log.Printf("Failed to fetch the pools of post %d: %v", post.ID, err)
+			return poolChoicesMsg{postID: post.ID, err: err}
+		}
+		return poolChoicesMsg{postID: post.ID, pools: pools}
+	}
+}
+
+// fetchPoolPostsCmd loads a chunk of the posts of the pool being read.
+func (m *model) fetchPoolPostsCmd(chunk int) tea.Cmd {
+	poolID := m.pool.pool.ID
+	ids := m.pool.pool.PostIDs[chunk*poolChunkSize : min((chunk+1)*poolChunkSize, len(m.pool.pool.PostIDs))]
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	tags := "id:" + strings.Join(s, ",")
+	if m.safeMode {
+		tags += " rating:s"
+	}
+
+	return func() tea.Msg {
+		req, err := http.NewRequest("GET", m.apiBase()+"/posts.json", nil)
+		if err != nil {
+			return poolPostsMsg{poolID: poolID, chunk: chunk, err: err}
+		}
+		q := req.URL.Query()
+		q.Add("tags", tags)
+		q.Add("limit", strconv.Itoa(poolChunkSize))
+		req.URL.RawQuery = q.Encode()
+		req.Header.Set("User-Agent", userAgent)
+
+		body, err := upstreamCache.get(context.Background(), m.postsCacheKey(req), m.postsCacheKind(), func(ctx context.Context) ([]byte, error) {
+			return fetchAPI(ctx, m.httpClient, req)
+		})
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			err = fmt.Errorf("API request failed with %w", statusErr)
+		}
+		if err != nil {
+			log.Printf("Failed to fetch posts of pool %d: %v", poolID, err)
+			return poolPostsMsg{poolID: poolID, chunk: chunk, err: err}
+		}
+
+		var postResp PostResponse
+		if err := json.Unmarshal(body, &postResp); err != nil {
+			return poolPostsMsg{poolID: poolID, chunk: chunk, err: err}
+		}
+		return poolPostsMsg{poolID: poolID, chunk: chunk, posts: postResp.Posts}
+	}
+}
+
+// openPoolOf reads a pool of the selected post, asking which one first if
+// it's in several.
+func (m *model) openPoolOf(post Post) tea.Cmd {
+	switch len(post.Pools) {
+	case 0:
+		m.statusMessage = "This post isn't in a pool"
+		return clearStatusCmd(2 * time.Second)
+	case 1:
+		return m.openPool(post.Pools[0], post.ID)
+	}
+	m.closePanes()
+	m.pool = poolReader{active: true, loading: true, picking: true, startPost: post.ID}
+	return tea.Batch(tea.ClearScreen, fetchPoolChoicesCmd(m.httpClient, m.apiBase(), post), m.spinner.Tick)
+}
+
+// openPool starts reading a pool at startPost, or at its first page if the
+// post isn't in it.
+func (m *model) openPool(id, startPost int) tea.Cmd {
+	m.closePanes()
+	m.pool = poolReader{active: true, loading: true, pool: pool{ID: id}, startPost: startPost}
+	return tea.Batch(tea.ClearScreen, fetchPoolCmd(m.httpClient, m.apiBase(), id), m.spinner.Tick)
+}
+
+// closePanes hides whatever is shown over the posts and stops the preview.
+func (m *model) closePanes() {
+	m.showTags = false
+	m.showDetails = false
+	m.comments = commentsView{}
+	if m.cancelPreview != nil {
+		m.cancelPreview()
+	}
+	m.animation = nil
+	m.previewViewport.SetContent("")
+}
+
+// closePool goes back to the posts.
+func (m *model) closePool() tea.Cmd {
+	m.pool = poolReader{}
+	m.closePanes()
+	if m.gridMode {
+		m.renderGridTiles()
+		return tea.ClearScreen
+	}
+	if len(m.posts) == 0 {
+		return tea.ClearScreen
+	}
+	return m.triggerPreviewUpdate()
+}
+
+// applyPoolChoices lists the pools of the post to pick from.
+func (m *model) applyPoolChoices(msg poolChoicesMsg) {
+	if !m.pool.picking || msg.postID != m.pool.startPost {
+		return
+	}
+	m.pool.loading = false
+	m.pool.err = msg.err
+	m.pool.choices = msg.pools
+	m.pool.choice = 0
+}
+
+// applyPool starts reading a pool once it's loaded.
+func (m *model) applyPool(msg poolFetchedMsg) tea.Cmd {
+	if !m.pool.active || m.pool.picking || msg.id != m.pool.pool.ID {
+		return nil
+	}
+	m.pool.loading = false
+	m.pool.err = msg.err
+	if msg.err != nil {
+		return nil
+	}
+	m.pool.pool = msg.pool
+	m.pool.posts = map[int]Post{}
+	m.pool.chunks = map[int]bool{}
+	m.pool.index = 0
+	for i, id := range msg.pool.PostIDs {
+		if id == m.pool.startPost {
+			m.pool.index = i
+		}
+	}
+	return m.showPoolPage()
+}
+
+// applyPoolPosts adds a chunk of posts to the pool, showing the current
+// page if it was waiting for them.
+func (m *model) applyPoolPosts(msg poolPostsMsg) tea.Cmd {
+	if !m.pool.active || msg.poolID != m.pool.pool.ID || m.pool.chunks == nil {
+		return nil
+	}
+	if msg.err != nil {
+		// Let the chunk be requested again when its pages are turned to.
+		delete(m.pool.chunks, msg.chunk)
+		m.statusMessage = fmt.Sprintf("Couldn't load the pool's posts: %v", msg.err)
+		if m.pool.index/poolChunkSize == msg.chunk {
+			m.previewViewport.SetContent("\nThis page couldn't be loaded. Turn back to it to try again.")
+		}
+		return clearStatusCmd(3 * time.Second)
+	}
+	m.pool.chunks[msg.chunk] = true
+	for _, p := range msg.posts {
+		m.pool.posts[p.ID] = p
+	}
+	if m.pool.index/poolChunkSize == msg.chunk {
+		return m.showPoolPage()
+	}
+	return nil
+}
+
+// poolPageLoading reports whether the current page is waiting for its chunk
+// of posts.
+func (m *model) poolPageLoading() bool {
+	r := &m.pool
+	if !r.active || r.loading || r.picking || r.index >= len(r.pool.PostIDs) {
+		return false
+	}
+	if _, ok := r.posts[r.pool.PostIDs[r.index]]; ok {
+		return false
+	}
+	loaded, requested := r.chunks[r.index/poolChunkSize]
+	return requested && !loaded
+}
+
+// turnPoolPage moves to page index of the pool.
+func (m *model) turnPoolPage(index int) tea.Cmd {
+	index = max(min(index, len(m.pool.pool.PostIDs)-1), 0)
+	if index == m.pool.index {
+		return nil
+	}
+	m.pool.index = index
+	return m.showPoolPage()
+}
+
+// poolPreviewArea returns where the pool reader draws pages.
+func (m *model) poolPreviewArea() (w, h, yOffset int) {
+	topBarHeight := lipgloss.Height(m.topBarView())
+	headerHeight := lipgloss.Height(m.poolHeaderView())
+	return m.width, m.contentHeight() - headerHeight, topBarHeight + headerHeight
+}
+
+// showPoolPage shows the post on the current page, fetching it first if
+// needed.
+func (m *model) showPoolPage() tea.Cmd {
+	if m.cancelPreview != nil {
+		m.cancelPreview()
+	}
+	m.animation = nil
+	ids := m.pool.pool.PostIDs
+	if len(ids) == 0 {
+		m.previewViewport.SetContent("\nThis pool is empty.")
+		return tea.ClearScreen
+	}
+
+	chunk := m.pool.index / poolChunkSize
+	post, ok := m.pool.posts[ids[m.pool.index]]
+	if !ok {
+		loaded, requested := m.pool.chunks[chunk]
+		switch {
+		case !requested:
+			// poolView shows the spinner until the chunk is in.
+			m.pool.chunks[chunk] = false
+			m.previewViewport.SetContent("")
+			return tea.Batch(tea.ClearScreen, m.fetchPoolPostsCmd(chunk), m.spinner.Tick)
+		case loaded && m.safeMode:
+			m.previewViewport.SetContent(fmt.Sprintf("\nPost #%d is hidden by safe mode, or it may have been deleted.", ids[m.pool.index]))
+			return tea.ClearScreen
+		case loaded:
+			m.previewViewport.SetContent(fmt.Sprintf("\nPost #%d isn't available. It may have been deleted.", ids[m.pool.index]))
+			return tea.ClearScreen
+		default:
+			m.previewViewport.SetContent("")
+			return tea.ClearScreen
+		}
+	}
+
+	var ctx context.Context
+	ctx, m.cancelPreview = context.WithCancel(context.Background())
+	if m.isBlacklisted(post) {
+		content := fmt.Sprintf("\nPost #%d is blacklisted. Press b on the posts to edit your blacklist.", post.ID)
+		if m.imageProtocol == protocolKitty {
+			content = kittyDeleteAll() + content
+		}
+		m.previewViewport.SetContent(content)
+		return tea.ClearScreen
+	}
+
+	m.previewViewport.SetContent(m.spinner.View() + " Loading preview...")
+	w, h, yOffset := m.poolPreviewArea()
+	cmds := []tea.Cmd{
+		tea.ClearScreen,
+		downloadAndRenderImage(ctx, m.httpClient, m.previewCache, m.getDisplayURL(post), m.imageProtocol, m.cellSize, w, h, 0, yOffset),
+	}
+
+	// Readers mostly go forward, so get the next page ready, and its chunk
+	// of posts when it's the last page of this one.
+	if next := m.pool.index + 1; next < len(ids) {
+		if p, ok := m.pool.posts[ids[next]]; ok {
+			url := m.getDisplayURL(p)
+			if m.imageProtocol != protocolNone && url != "" && !isVideo(strings.ToLower(path.Ext(url))) && !m.isBlacklisted(p) {
+				cmds = append(cmds, prefetchPreviewCmd(m.prefetchCtx, m.httpClient, m.previewCache, url, m.imageProtocol, m.cellSize, w, h, 0, yOffset))
+			}
+		} else if _, requested := m.pool.chunks[next/poolChunkSize]; !requested {
+			m.pool.chunks[next/poolChunkSize] = false
+			cmds = append(cmds, m.fetchPoolPostsCmd(next/poolChunkSize))
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+// currentPoolPost returns the post on the current page, if it's loaded.
+func (m *model) currentPoolPost() (Post, bool) {
+	ids := m.pool.pool.PostIDs
+	if m.pool.index >= len(ids) {
+		return Post{}, false
+	}
+	p, ok := m.pool.posts[ids[m.pool.index]]
+	return p, ok
+}
+
+// updatePool handles keys in the pool reader.
+func (m *model) updatePool(msg tea.KeyMsg) tea.Cmd {
+	r := &m.pool
+	if r.picking {
+		switch {
+		case key.Matches(msg, key.NewBinding(key.WithKeys("esc", "q"))):
+			return m.closePool()
+		case key.Matches(msg, key.NewBinding(key.WithKeys("k", "up"))):
+			r.choice = max(r.choice-1, 0)
+		case key.Matches(msg, key.NewBinding(key.WithKeys("j", "down"))):
+			r.choice = max(min(r.choice+1, len(r.choices)-1), 0)
+		case key.Matches(msg, key.NewBinding(key.WithKeys("enter"))):
+			if r.choice < len(r.choices) {
+				return m.openPool(r.choices[r.choice].ID, r.startPost)
+			}
+		}
+		return nil
+	}
+
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("esc", "q"))):
+		return m.closePool()
+	case r.loading || r.err != nil:
+		return nil
+	case key.Matches(msg, key.NewBinding(key.WithKeys("n", "l", "right", " ", "pgdown"))):
+		return m.turnPoolPage(r.index + 1)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("p", "h", "left", "pgup"))):
+		return m.turnPoolPage(r.index - 1)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("home", "g"))):
+		return m.turnPoolPage(0)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("end", "G"))):
+		return m.turnPoolPage(len(r.pool.PostIDs) - 1)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("d"))):
+		if r.pool.Description != "" {
+			r.hideDescription = !r.hideDescription
+			return m.showPoolPage()
+		}
+	case key.Matches(msg, key.NewBinding(key.WithKeys("e"))):
+		m.showFullImage = !m.showFullImage
+		m.settings.ShowFullImage = m.showFullImage
+		m.saveSettings()
+		return m.showPoolPage()
+	case key.Matches(msg, key.NewBinding(key.WithKeys("c"))):
+		if p, ok := m.currentPoolPost(); ok {
+			m.statusMessage = "Copied link to clipboard!"
+			return tea.Batch(copyToClipboardCmd(p.File.URL), clearStatusCmd(2*time.Second))
+		}
+	}
+	return nil
+}
+
+// poolHeaderView renders the pool's name, the position in it and its
+// description above the pages.
+func (m *model) poolHeaderView() string {
+	r := &m.pool
+	if r.picking || r.loading || r.err != nil {
+		return ""
+	}
+	title := detailTitleStyle.Render(truncate(r.pool.title(), max(m.width/2, 10)))
+	info := fmt.Sprintf(" · page %d/%d", r.index+1, len(r.pool.PostIDs))
+	if len(r.pool.PostIDs) == 0 {
+		info = " · empty"
+	}
+	if r.pool.Category != "" {
+		info += " · " + sanitizeText(r.pool.Category)
+	}
+	if r.pool.CreatorName != "" {
+		info += " · by " + sanitizeText(r.pool.CreatorName)
+	}
+	if p, ok := m.currentPoolPost(); ok {
+		info += fmt.Sprintf(" · post #%d", p.ID)
+	}
+	lines := []string{title + detailLabelStyle.Render(info)}
+
+	if r.pool.Description != "" && !r.hideDescription {
+		text, _ := renderDText(r.pool.Description, m.width-2, newDTextState())
+		description := strings.Split(text, "\n")
+		if len(description) > poolDescriptionLines {
+			description = append(description[:poolDescriptionLines-1], helpStyle.Render("… d: hide description"))
+		}
+		lines = append(lines, description...)
+	}
+	return lipgloss.NewStyle().Padding(0, 1).Render(strings.Join(lines, "\n"))
+}
+
+// poolView renders the pool reader, or the list of pools to pick from.
+func (m *model) poolView(height int) string {
+	r := &m.pool
+	var body string
+	switch {
+	case r.loading && r.picking:
+		body = m.spinner.View() + " Loading pools..."
+	case r.loading:
+		body = m.spinner.View() + " Loading pool..."
+	case r.err != nil:
+		body = lipgloss.NewStyle().Foreground(errorColor).Render(sanitizeText(fmt.Sprintf("Couldn't load the pool: %v", r.err)))
+	case r.picking:
+		body = m.poolChoicesView(height - 2)
+	default:
+		header := m.poolHeaderView()
+		w := m.width
+		h := height - lipgloss.Height(header)
+		m.previewViewport.Width = w - 2
+		m.previewViewport.Height = h - 2
+		content := m.previewViewport.View()
+		if m.poolPageLoading() {
+			content = m.spinner.View() + " Loading pages..."
+		}
+		page := previewPaneStyle.Width(w).Height(h).Render(content)
+		return lipgloss.JoinVertical(lipgloss.Left, header, page)
+	}
+
+	view := paneStyle.Width(m.width).Height(height).Render(body)
+	if m.imageProtocol == protocolKitty {
+		view = kittyDeleteAll() + view
+	}
+	return view
+}
+
+// poolChoicesView lists the pools of the post.
+func (m *model) poolChoicesView(height int) string {
+	r := &m.pool
+	lines := []string{detailTitleStyle.Render(fmt.Sprintf("Post #%d is in %d pools", r.startPost, len(r.choices))), ""}
+	for i, p := range r.choices {
+		name := truncate(p.title(), max(m.width-30, 10))
+		info := detailLabelStyle.Render(fmt.Sprintf(" · %d posts · %s", p.PostCount, sanitizeText(p.Category)))
+		if i == r.choice {
+			lines = append(lines, "› "+lipgloss.NewStyle().Foreground(highlight).Render(name)+info)
+		} else {
+			lines = append(lines, "  "+name+info)
+		}
+	}
+	if len(r.choices) == 0 {
+		lines = append(lines, helpStyle.Render("None of them could be found."))
+	}
+
+	offset := 0
+	if len(lines) > height {
+		offset = min(max(r.choice+2-height/2, 0), len(lines)-height)
+	}
+	return strings.Join(lines[offset:min(offset+height, len(lines))], "\n")
+}
+
+// poolHelp is the status bar text in the pool reader.
+func (m *model) poolHelp() string {
+	r := &m.pool
+	switch {
+	case r.picking && !r.loading:
+		return "↑/↓: pick pool | enter: read | esc: cancel"
+	case r.loading || r.err != nil:
+		return "esc: close pool"
+	}
+	imageModeText := "full/[sample]"
+	if m.showFullImage {
+		imageModeText = "[full]/sample"
+	}
+	help := fmt.Sprintf("n/→: next | p/←: previous | g/G: first/last | c: copy url | e: %s", imageModeText)
+	if r.pool.Description != "" {
+		if r.hideDescription {
+			help += " | d: show description"
+		} else {
+			help += " | d: hide description"
+		}
+	}
+	return help + " | esc: close pool"
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- Query Syntax ---
+//
+// Searches use e621's syntax: space separated tags that all have to match,
+// "-tag" for tags that must not match, "~tag" for tags of which at least one
+// has to match, parentheses to group terms and * as a wildcard. "name:value"
+// terms are metatags. Queries are parsed to highlight them, to catch mistakes
+// before they're sent and to normalize them, so that the same search always
+// has the same cache key.
+
+// maxQueryTags is the most tags e621 searches at once.
+const maxQueryTags = 40
+
+var (
+	operatorStyle = lipgloss.NewStyle().Foreground(highlight)
+	invalidStyle  = lipgloss.NewStyle().Foreground(errorColor).Underline(true)
+	warningStyle  = lipgloss.NewStyle().Foreground(warningColor).Underline(true)
+)
+
+type queryTokenKind int
+
+const (
+	tokenTag queryTokenKind = iota
+	tokenWildcard
+	tokenMetatag
+	tokenGroupStart
+	tokenGroupEnd
+)
+
+// queryToken is a single term of a query.
+type queryToken struct {
+	kind   queryTokenKind
+	start  int    // Byte offset in the query.
+	text   string // The term as typed, with its prefix.
+	prefix string // "-" or "~", if any.
+	name   string // Of metatags.
+	value  string // Of metatags.
+	err    error  // Why the term is invalid, nil if it's fine.
+	warn   error  // Why the term looks wrong, without stopping the search.
+}
+
+type parsedQuery struct {
+	tokens []queryToken
+}
+
+// metatags are the metatags e621 knows, with a check of their values.
+var metatags = map[string]func(string) error{
+	"order":  validateOrder,
+	"rating": oneOf("s", "q", "e", "safe", "questionable", "explicit"),
+	"type":   oneOf("jpg", "png", "gif", "swf", "webm", "mp4", "webp"),
+	"status": oneOf("pending", "active", "deleted", "flagged", "modqueue", "any"),
+
+	"id":            validateIDs,
+	"score":         validateRange,
+	"favcount":      validateRange,
+	"width":         validateRange,
+	"height":        validateRange,
+	"tagcount":      validateRange,
+	"gentags":       validateRange,
+	"arttags":       validateRange,
+	"chartags":      validateRange,
+	"copytags":      validateRange,
+	"spectags":      validateRange,
+	"invtags":       validateRange,
+	"lortags":       validateRange,
+	"metatags":      validateRange,
+	"comment_count": validateRange,
+	"limit":         validateRange,
+	"randseed":      validateRange,
+
+	// Dates, sizes and ratios come in too many formats to check here.
+	"date":     anyValue,
+	"mpixels":  anyValue,
+	"ratio":    anyValue,
+	"filesize": anyValue,
+	"duration": anyValue,
+
+	"pool":        anyValue,
+	"set":         anyValue,
+	"fav":         anyValue,
+	"favoritedby": anyValue,
+	"user":        anyValue,
+	"approver":    anyValue,
+	"commenter":   anyValue,
+	"noter":       anyValue,
+	"deletedby":   anyValue,
+	"voted":       anyValue,
+	"votedup":     anyValue,
+	"voteddown":   anyValue,
+	"upvote":      anyValue,
+	"downvote":    anyValue,
+	"md5":         anyValue,
+	"source":      anyValue,
+	"description": anyValue,
+	"note":        anyValue,
+	"delreason":   anyValue,
+	"parent":      anyValue,
+	"child":       anyValue,
+
+	"ischild":              oneOf("true", "false"),
+	"isparent":             oneOf("true", "false"),
+	"inpool":               oneOf("true", "false"),
+	"pending_replacements": oneOf("true", "false"),
+	"artverified":          oneOf("true", "false"),
+	"hassource":            oneOf("true", "false"),
+	"hasdescription":       oneOf("true", "false"),
+	"ratinglocked":         oneOf("true", "false"),
+	"notelocked":           oneOf("true", "false"),
+	"statuslocked":         oneOf("true", "false"),
+}
+
+// caseSensitiveMetatags keep the case of their values when normalized.
+var caseSensitiveMetatags = map[string]bool{
+	"source":      true,
+	"description": true,
+	"note":        true,
+	"delreason":   true,
+}
+
+var orderValues = map[string]bool{
+	"id": true, "score": true, "favcount": true, "created": true, "created_at": true,
+	"updated": true, "updated_at": true, "comment": true, "comment_count": true,
+	"comment_bumped": true, "note": true, "mpixels": true, "aspect_ratio": true,
+	"ratio": true, "landscape": true, "portrait": true, "filesize": true,
+	"tagcount": true, "gentags": true, "arttags": true, "chartags": true,
+	"copytags": true, "spectags": true, "invtags": true, "lortags": true,
+	"metatags": true, "change": true, "duration": true, "rank": true,
+	"random": true, "hot": true, "md5": true,
+}
+
+func anyValue(string) error { return nil }
+
+func oneOf(values ...string) func(string) error {
+	return func(v string) error {
+		for _, allowed := range values {
+			if v == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}
+}
+
+func validateRange(v string) error {
+	if _, ok := parseNumericRange(v); !ok {
+		return fmt.Errorf("%q is not a number or range", v)
+	}
+	return nil
+}
+
+// validateIDs also accepts comma separated lists of IDs.
+func validateIDs(v string) error {
+	for _, id := range strings.Split(v, ",") {
+		if err := validateRange(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateOrder(v string) error {
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "_asc"), "_desc")
+	if !orderValues[v] {
+		return fmt.Errorf("can't sort by %q", v)
+	}
+	return nil
+}
+
+// parseQuery splits a query into its terms and checks each of them.
+func parseQuery(query string) parsedQuery {
+	var p parsedQuery
+	for i := 0; i < len(query); {
+		if query[i] == ' ' || query[i] == '\t' {
+			i++
+			continue
+		}
+		end := strings.IndexAny(query[i:], " \t")
+		if end < 0 {
+			end = len(query)
+		} else {
+			end += i
+		}
+		p.tokens = append(p.tokens, parseQueryToken(query[i:end], i))
+		i = end
+	}
+	return p
+}
+
+func parseQueryToken(text string, start int) queryToken {
+	t := queryToken{kind: tokenTag, start: start, text: text}
+	body := text
+	if body != "" && (body[0] == '-' || body[0] == '~') && body != "-" && body != "~" {
+		t.prefix, body = body[:1], body[1:]
+	}
+
+	switch {
+	case body == "(":
+		t.kind = tokenGroupStart
+	case body == ")":
+		t.kind = tokenGroupEnd
+		if t.prefix != "" {
+			t.err = fmt.Errorf("%s can't be used on a closing parenthesis", t.prefix)
+		}
+	case isMetatag(body):
+		t.kind = tokenMetatag
+		name, value, _ := strings.Cut(body, ":")
+		t.name, t.value = strings.ToLower(name), value
+		validate, ok := metatags[t.name]
+		switch {
+		case !ok:
+			// e621 adds metatags now and then, so it gets the final say.
+			t.warn = fmt.Errorf("unknown metatag %s:", t.name)
+		case value == "":
+			t.err = fmt.Errorf("%s: needs a value", t.name)
+		default:
+			if !caseSensitiveMetatags[t.name] {
+				value = strings.ToLower(value)
+			}
+			if err := validate(value); err != nil {
+				t.err = fmt.Errorf("invalid %s: %w", t.name, err)
+			}
+		}
+	case strings.Contains(body, "*"):
+		t.kind = tokenWildcard
+	}
+	return t
+}
+
+// isMetatag reports whether a term looks like name:value. Tags like :3 or
+// 16:9 aren't metatags.
+func isMetatag(term string) bool {
+	name, _, ok := strings.Cut(term, ":")
+	if !ok || name == "" {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// tagCount returns the number of terms e621 counts towards maxQueryTags.
+func (p parsedQuery) tagCount() int {
+	n := 0
+	for _, t := range p.tokens {
+		if t.kind != tokenGroupStart && t.kind != tokenGroupEnd {
+			n++
+		}
+	}
+	return n
+}
+
+// check returns the first problem with the query, allowing at most limit
+// tags.
+func (p parsedQuery) check(limit int) error {
+	depth := 0
+	for _, t := range p.tokens {
+		if t.err != nil {
+			return t.err
+		}
+		switch t.kind {
+		case tokenGroupStart:
+			depth++
+		case tokenGroupEnd:
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("unmatched )")
+			}
+		}
+	}
+	if depth > 0 {
+		return fmt.Errorf("unmatched (")
+	}
+	if n := p.tagCount(); n > limit {
+		return fmt.Errorf("%d tags, e621 searches at most %d", n, limit)
+	}
+	return nil
+}
+
+// warning returns the first term that looks wrong but can still be searched.
+func (p parsedQuery) warning() error {
+	for _, t := range p.tokens {
+		if t.warn != nil {
+			return t.warn
+		}
+	}
+	return nil
+}
+
+// normalized returns the term in the form it's sent to e621.
+func (t queryToken) normalized() string {
+	if t.kind == tokenMetatag && caseSensitiveMetatags[t.name] {
+		return t.prefix + t.name + ":" + t.value
+	}
+	return strings.ToLower(t.text)
+}
+
+// normalizeQuery lowercases the terms of a query, collapses the whitespace
+// between them and drops repeated terms, so equal searches are sent the same
+// way.
+func normalizeQuery(query string) string {
+	p := parseQuery(query)
+	grouped := false
+	for _, t := range p.tokens {
+		if t.kind == tokenGroupStart {
+			grouped = true
+		}
+	}
+
+	seen := map[string]bool{}
+	var terms []string
+	for _, t := range p.tokens {
+		term := t.normalized()
+		// Inside groups a repeated term can mean something else.
+		if !grouped {
+			if seen[term] {
+				continue
+			}
+			seen[term] = true
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// tagLimit is how many tags the user can search for themselves.
+func (m *model) tagLimit() int {
+	if m.safeMode {
+		return maxQueryTags - 1 // rating:s is added.
+	}
+	return maxQueryTags
+}
+
+// checkQuery returns why a query can't be searched, or nil if it can.
+func (m *model) checkQuery(query string) error {
+	if err := parseQuery(query).check(m.tagLimit()); err != nil {
+		return err
+	}
+	return m.checkSafeQuery(query)
+}
+
+// queryStyles returns the style of each byte of the query.
+func (m *model) queryStyles(query string) []lipgloss.Style {
+	styles := make([]lipgloss.Style, len(query))
+	for i := range styles {
+		styles[i] = defaultTextStyle
+	}
+	paint := func(from, to int, style lipgloss.Style) {
+		for i := from; i < to; i++ {
+			styles[i] = style
+		}
+	}
+
+	count := 0
+	for _, t := range parseQuery(query).tokens {
+		end := t.start + len(t.text)
+		body := t.start + len(t.prefix)
+		paint(t.start, body, operatorStyle)
+		if t.kind != tokenGroupStart && t.kind != tokenGroupEnd {
+			count++
+		}
+
+		switch {
+		case t.err != nil || count > m.tagLimit():
+			paint(body, end, invalidStyle)
+		case t.warn != nil:
+			paint(body, end, warningStyle)
+		case t.kind == tokenGroupStart || t.kind == tokenGroupEnd:
+			paint(body, end, operatorStyle)
+		case t.kind == tokenMetatag:
+			colon := body + len(t.name) + 1
+			paint(body, colon, keyStyle)
+			paint(colon, end, valueStyle)
+		case t.kind == tokenWildcard:
+			for i := body; i < end; i++ {
+				if query[i] == '*' {
+					styles[i] = operatorStyle
+				}
+			}
+		}
+	}
+	return styles
+}
+
+// styledQueryText renders the search box's query with its syntax highlighted
+// and the cursor drawn in.
+func (m *model) styledQueryText() string {
+	val := m.searchBox.Value()
+	cursorPos := m.searchBox.Position()
+	cursorStyle := m.searchBox.Cursor.Style
+	styles := m.queryStyles(val)
+
+	var styledParts []string
+	runeIdx := 0
+	for i, r := range val {
+		char := string(r)
+		if runeIdx == cursorPos && m.searchBox.Cursor.Blink {
+			char = m.searchBox.Cursor.View()
+		} else if runeIdx == cursorPos {
+			char = cursorStyle.Render(char)
+		}
+		styledParts = append(styledParts, styles[i].Render(char))
+		runeIdx++
+	}
+
+	if cursorPos >= utf8.RuneCountInString(val) {
+		if m.searchBox.Cursor.Blink {
+			styledParts = append(styledParts, m.searchBox.Cursor.View())
+		} else {
+			styledParts = append(styledParts, cursorStyle.Render(" "))
+		}
+	}
+
+	text := lipgloss.JoinHorizontal(lipgloss.Top, styledParts...)
+	if err := m.checkQuery(val); err != nil {
+		text += "  " + lipgloss.NewStyle().Foreground(errorColor).Render(err.Error())
+	} else if warn := parseQuery(val).warning(); warn != nil {
+		text += "  " + lipgloss.NewStyle().Foreground(warningColor).Render(warn.Error())
+	}
+	return text
+}
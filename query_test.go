@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		kinds   []queryTokenKind
+		err     string // Substring of check's error, empty if it's fine.
+		warning string // Substring of the warning, empty if there's none.
+	}{
+		{"", nil, "", ""},
+		{"cat  dog", []queryTokenKind{tokenTag, tokenTag}, "", ""},
+		{"-cat ~dog ~fox", []queryTokenKind{tokenTag, tokenTag, tokenTag}, "", ""},
+		{"cat*", []queryTokenKind{tokenWildcard}, "", ""},
+		{":3 16:9", []queryTokenKind{tokenTag, tokenTag}, "", ""},
+		{"rating:s order:score_asc", []queryTokenKind{tokenMetatag, tokenMetatag}, "", ""},
+		{"score:>=10 id:1,2..5", []queryTokenKind{tokenMetatag, tokenMetatag}, "", ""},
+		{"( cat ~ dog )", []queryTokenKind{tokenGroupStart, tokenTag, tokenTag, tokenTag, tokenGroupEnd}, "", ""},
+
+		{"rating:x", []queryTokenKind{tokenMetatag}, "invalid rating", ""},
+		{"order:likes", []queryTokenKind{tokenMetatag}, "can't sort", ""},
+		{"score:lots", []queryTokenKind{tokenMetatag}, "not a number", ""},
+		{"score:", []queryTokenKind{tokenMetatag}, "needs a value", ""},
+		{"( cat", []queryTokenKind{tokenGroupStart, tokenTag}, "unmatched (", ""},
+		{"cat )", []queryTokenKind{tokenTag, tokenGroupEnd}, "unmatched )", ""},
+		{"( cat -)", []queryTokenKind{tokenGroupStart, tokenTag, tokenGroupEnd}, "can't be used on a closing", ""},
+
+		{"newtag:value cat", []queryTokenKind{tokenMetatag, tokenTag}, "", "unknown metatag newtag:"},
+		{"rating:x newtag:value", nil, "invalid rating", "unknown metatag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			p := parseQuery(tt.query)
+			if tt.kinds != nil {
+				var kinds []queryTokenKind
+				for _, tok := range p.tokens {
+					kinds = append(kinds, tok.kind)
+				}
+				if len(kinds) != len(tt.kinds) {
+					t.Fatalf("got tokens %v, want %v", kinds, tt.kinds)
+				}
+				for i := range kinds {
+					if kinds[i] != tt.kinds[i] {
+						t.Errorf("got tokens %v, want %v", kinds, tt.kinds)
+						break
+					}
+				}
+			}
+
+			err := p.check(maxQueryTags)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("check() = %v, want nil", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("check() = %v, want %q", err, tt.err)
+			}
+			warn := p.warning()
+			switch {
+			case tt.warning == "" && warn != nil:
+				t.Errorf("warning() = %v, want nil", warn)
+			case tt.warning != "" && (warn == nil || !strings.Contains(warn.Error(), tt.warning)):
+				t.Errorf("warning() = %v, want %q", warn, tt.warning)
+			}
+		})
+	}
+}
+
+func TestQueryTagLimit(t *testing.T) {
+	query := strings.TrimSpace(strings.Repeat("cat ", maxQueryTags) + "( dog )")
+	if err := parseQuery(query).check(maxQueryTags); err == nil || !strings.Contains(err.Error(), "41 tags") {
+		t.Errorf("check() = %v, want too many tags", err)
+	}
+	if err := parseQuery(query).check(maxQueryTags + 1); err != nil {
+		t.Errorf("check() with a higher limit = %v, want nil", err)
+	}
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"", ""},
+		{"  Cat   Dog\t", "cat dog"},
+		{"cat dog cat", "cat dog"},
+		{"-Cat -cat ~dog", "-cat ~dog"},
+		{"Rating:S Order:Score", "rating:s order:score"},
+		{"description:Hello World", "description:Hello world"},
+		{"-Source:Twitter.com/Foo", "-source:Twitter.com/Foo"},
+		{"( cat ~ dog ) ( cat ~ fox )", "( cat ~ dog ) ( cat ~ fox )"},
+		{"NewTag:Value", "newtag:value"},
+	}
+	for _, tt := range tests {
+		if got := normalizeQuery(tt.query); got != tt.want {
+			t.Errorf("normalizeQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// --- Rate Limiting ---
+//
+// e621 asks API clients to stay around 1-2 requests per second. All sessions
+// share one limiter, and back off when the API tells us to slow down.
+
+const (
+	apiRequestsPerSecond = 1
+	apiBurst             = 2
+	// maxRetries is how often a request is retried before giving up.
+	maxRetries = 5
+	// maxImageRetries is lower, as someone is looking at the spinner.
+	maxImageRetries = 2
+	// maxBackoff caps the exponential backoff between retries.
+	maxBackoff = 30 * time.Second
+)
+
+// apiLimiter is shared by every request to the e621 API.
+var apiLimiter = newRateLimiter(apiRequestsPerSecond, apiBurst)
+
+// rateLimiter is a token bucket that can additionally be paused, for when
+// the server sends a Retry-After.
+type rateLimiter struct {
+	mu        sync.Mutex
+	interval  time.Duration // Time to refill one token.
+	burst     float64
+	tokens    float64
+	last      time.Time
+	notBefore time.Time
+}
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		interval: time.Duration(float64(time.Second) / perSecond),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// wait blocks until a request may be made or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens = min(l.burst, l.tokens+float64(now.Sub(l.last))/float64(l.interval))
+		l.last = now
+
+		var delay time.Duration
+		switch {
+		case now.Before(l.notBefore):
+			delay = l.notBefore.Sub(now)
+		case l.tokens >= 1:
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		default:
+			delay = time.Duration((1 - l.tokens) * float64(l.interval))
+		}
+		l.mu.Unlock()
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// pause holds back all requests for d.
+func (l *rateLimiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.notBefore) {
+		l.notBefore = until
+	}
+}
+
+// fetchAPI performs a request to the e621 API through the shared limiter.
+// When the mirror the request was made for is down, the others are tried.
+func fetchAPI(ctx context.Context, client *http.Client, req *http.Request) ([]byte, error) {
+	mirrors, first := findMirror(req.URL)
+	if mirrors == nil {
+		return fetchLimited(ctx, client, req)
+	}
+
+	var lastErr error
+	for n := range mirrors.hosts {
+		i := (first + n) % len(mirrors.hosts)
+		r := req
+		if i != first {
+			var err error
+			if r, err = mirrors.withMirror(req, first, i); err != nil {
+				return nil, err
+			}
+		}
+		data, err := fetchLimited(ctx, client, r)
+		if !shouldFailOver(req.Method, err) || ctx.Err() != nil {
+			if err == nil {
+				mirrors.current.Store(int64(i))
+			}
+			return data, err
+		}
+		if len(mirrors.hosts) > 1 {
+			log.Printf("Mirror %s failed: %v", mirrors.hosts[i], err)
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// fetchLimited performs a single request once the limiter allows it. When the
+// API says we're going too fast, everyone is paused accordingly.
+func fetchLimited(ctx context.Context, client *http.Client, req *http.Request) ([]byte, error) {
+	if err := apiLimiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	data, err := fetchBody(ctx, client, req, maxAPIResponseSize)
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && (statusErr.code == http.StatusTooManyRequests || statusErr.code == http.StatusServiceUnavailable) {
+		delay, _ := retryDelay(err, 0)
+		apiLimiter.pause(delay)
+	}
+	return data, err
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as a date.
+func parseRetryAfter(h string) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// retryDelay reports whether err is worth retrying, and how long to wait
+// before the given (zero-based) retry: Retry-After if the server sent one,
+// otherwise exponential backoff with some jitter.
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+	var statusErr *statusError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &statusErr):
+		if statusErr.code != http.StatusTooManyRequests && statusErr.code < 500 {
+			return 0, false
+		}
+		if statusErr.retryAfter > 0 {
+			return min(statusErr.retryAfter, maxBackoff), true
+		}
+	case errors.As(err, &netErr) && netErr.Timeout():
+	default:
+		return 0, false
+	}
+
+	backoff := min(time.Second<<attempt, maxBackoff)
+	jitter := time.Duration(rand.Int64N(int64(backoff)/5 + 1))
+	return backoff + jitter, true
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
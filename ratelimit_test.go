@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		min, max time.Duration
+	}{
+		{"missing", "", 0, 0},
+		{"seconds", "120", 2 * time.Minute, 2 * time.Minute},
+		{"zero seconds", "0", 0, 0},
+		{"negative seconds", "-5", 0, 0},
+		{"garbage", "soon", 0, 0},
+		{"date", time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat), 85 * time.Second, 90 * time.Second},
+		{"past date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+		{"RFC 850 date", time.Now().Add(time.Hour).UTC().Format(time.RFC850), 59 * time.Minute, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.header); got < tt.min || got > tt.max {
+				t.Errorf("parseRetryAfter(%q) = %s, want between %s and %s", tt.header, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempt  int
+		retry    bool
+		min, max time.Duration
+	}{
+		{"rate limited with Retry-After", &statusError{code: http.StatusTooManyRequests, retryAfter: 7 * time.Second}, 3, true, 7 * time.Second, 7 * time.Second},
+		{"Retry-After is capped", &statusError{code: http.StatusServiceUnavailable, retryAfter: time.Hour}, 0, true, maxBackoff, maxBackoff},
+		{"rate limited", &statusError{code: http.StatusTooManyRequests}, 0, true, time.Second, 1200 * time.Millisecond},
+		{"server error backs off", &statusError{code: http.StatusBadGateway}, 2, true, 4 * time.Second, 4800 * time.Millisecond},
+		{"backoff is capped", &statusError{code: http.StatusInternalServerError}, 10, true, maxBackoff, maxBackoff * 6 / 5},
+		{"timeout", timeoutError{}, 1, true, 2 * time.Second, 2400 * time.Millisecond},
+		{"not found", &statusError{code: http.StatusNotFound}, 0, false, 0, 0},
+		{"forbidden", &statusError{code: http.StatusForbidden}, 0, false, 0, 0},
+		{"other error", errors.New("connection refused"), 0, false, 0, 0},
+		{"no error", nil, 0, false, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, retry := retryDelay(tt.err, tt.attempt)
+			if retry != tt.retry {
+				t.Fatalf("retryDelay(%v, %d) retries = %v, want %v", tt.err, tt.attempt, retry, tt.retry)
+			}
+			if got < tt.min || got > tt.max {
+				t.Errorf("retryDelay(%v, %d) = %s, want between %s and %s", tt.err, tt.attempt, got, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// --- Safe Mode ---
+//
+// In safe mode posts come from e926, e621's safe for work mirror, and every
+// search is limited to rating:s on top of that. The server can force it on
+// for everyone with E6TEA_SAFE_MODE, otherwise users can turn it on for
+// themselves.
+
+// serverSafeMode is set from E6TEA_SAFE_MODE when the server starts.
+var serverSafeMode bool
+
+func setupSafeMode() {
+	serverSafeMode, _ = strconv.ParseBool(os.Getenv("E6TEA_SAFE_MODE"))
+	if serverSafeMode {
+		log.Println("Safe mode is on for all users.")
+	}
+}
+
+// apiBase returns the host API requests go to.
+func (m *model) apiBase() string {
+	if m.safeMode {
+		return safeAPIMirrors.base()
+	}
+	return apiMirrors.base()
+}
+
+// searchTags returns the tags actually searched for the current query.
+func (m *model) searchTags() string {
+	query := normalizeQuery(m.query)
+	if m.safeMode {
+		return strings.TrimSpace(query + " rating:s")
+	}
+	return query
+}
+
+// checkSafeQuery rejects searches for questionable or explicit posts while
+// safe mode is on.
+func (m *model) checkSafeQuery(query string) error {
+	if !m.safeMode {
+		return nil
+	}
+	for _, t := range parseQuery(query).tokens {
+		value := strings.ToLower(t.value)
+		if t.kind == tokenMetatag && t.name == "rating" && t.prefix != "-" && value != "" && value[0] != 's' {
+			return fmt.Errorf("safe mode is on, %s can't be searched", t.text)
+		}
+	}
+	return nil
+}
+
+// toggleSafeMode turns safe mode on or off for the user, unless the server
+// forces it.
+func (m *model) toggleSafeMode() {
+	if serverSafeMode {
+		m.statusMessage = "Safe mode is always on on this server"
+		return
+	}
+	m.safeMode = !m.safeMode
+	m.settings.SafeMode = m.safeMode
+	m.saveSettings()
+}
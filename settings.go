@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// --- User Settings ---
+//
+// Users connecting with a public key get their settings remembered, keyed by
+// the key's fingerprint. Each user's settings are a JSON file in the settings
+// directory. Linked e621 credentials are encrypted with a key only the server
+// knows.
+
+// userSettings is what's remembered between sessions.
+type userSettings struct {
+	ShowFullImage bool          `json:"show_full_image"`
+	ImageProtocol string        `json:"image_protocol,omitempty"` // Empty when negotiated.
+	Blacklist     string        `json:"blacklist,omitempty"`
+	SafeMode      bool          `json:"safe_mode,omitempty"`
+	Credentials   string        `json:"credentials,omitempty"` // Sealed with settingsStore.seal.
+	History       []string      `json:"history,omitempty"`     // Newest first.
+	SavedSearches []savedSearch `json:"saved_searches,omitempty"`
+}
+
+// settingsStore is nil when settings aren't persisted.
+var settings *settingsStore
+
+type settingsStore struct {
+	dir   string
+	aead  cipher.AEAD
+	locks sync.Map // User id to *sync.Mutex, held while updating their file.
+}
+
+// setupSettings opens the settings store in E6TEA_SETTINGS_DIR, "settings" by
+// default. The encryption key is read from E6TEA_SETTINGS_KEY (32 bytes in
+// base64), or from a key file in the directory that's created on first use.
+func setupSettings() {
+	dir := os.Getenv("E6TEA_SETTINGS_DIR")
+	if dir == "" {
+		dir = "settings"
+	}
+	store, err := newSettingsStore(dir, os.Getenv("E6TEA_SETTINGS_KEY"))
+	if err != nil {
+		log.Printf("Not remembering user settings: %v", err)
+		return
+	}
+	settings = store
+	log.Printf("User settings are stored in %q", dir)
+}
+
+func newSettingsStore(dir, encodedKey string) (*settingsStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	var key []byte
+	var err error
+	if encodedKey != "" {
+		key, err = base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid E6TEA_SETTINGS_KEY: %w", err)
+		}
+	} else {
+		key, err = loadOrCreateKey(filepath.Join(dir, "secret.key"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid settings key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &settingsStore{dir: dir, aead: aead}, nil
+}
+
+// loadOrCreateKey reads a 256 bit key from path, generating it if the file
+// doesn't exist yet.
+func loadOrCreateKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, key, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write settings key: %w", err)
+	}
+	log.Printf("Generated a new settings key in %s", path)
+	return key, nil
+}
+
+// userID identifies a user by their public key. It's empty for users who
+// didn't authenticate with one.
+func userID(s ssh.Session) string {
+	key := s.PublicKey()
+	if key == nil {
+		return ""
+	}
+	sum := sha256.Sum256(key.Marshal())
+	return hex.EncodeToString(sum[:])
+}
+
+// fingerprint returns the fingerprint of the session's public key in the
+// format OpenSSH shows it.
+func fingerprint(s ssh.Session) string {
+	if s.PublicKey() == nil {
+		return ""
+	}
+	return gossh.FingerprintSHA256(s.PublicKey())
+}
+
+func (s *settingsStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// load returns the settings of user id, or the defaults for a new user.
+func (s *settingsStore) load(id string) (userSettings, error) {
+	var us userSettings
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return us, nil
+	}
+	if err != nil {
+		return us, err
+	}
+	err = json.Unmarshal(data, &us)
+	return us, err
+}
+
+// save writes the settings of user id atomically.
+func (s *settingsStore) save(id string, us userSettings) error {
+	data, err := json.MarshalIndent(us, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(id))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// update merges the changes a session made to the settings of user id, from
+// base to ours, into what's saved now, so sessions sharing a key don't undo
+// each other's changes. It returns the merged settings.
+func (s *settingsStore) update(id string, base, ours userSettings) (userSettings, error) {
+	mu, _ := s.locks.LoadOrStore(id, new(sync.Mutex))
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	theirs, err := s.load(id)
+	if err != nil {
+		return ours, err
+	}
+	merged := mergeSettings(base, ours, theirs)
+	return merged, s.save(id, merged)
+}
+
+// mergeSettings applies the changes from base to ours to theirs. Settings
+// changed on both sides take our value, except that history and saved
+// searches keep the other side's additions.
+func mergeSettings(base, ours, theirs userSettings) userSettings {
+	merged := cloneSettings(theirs)
+	if ours.ShowFullImage != base.ShowFullImage {
+		merged.ShowFullImage = ours.ShowFullImage
+	}
+	if ours.ImageProtocol != base.ImageProtocol {
+		merged.ImageProtocol = ours.ImageProtocol
+	}
+	if ours.Blacklist != base.Blacklist {
+		merged.Blacklist = ours.Blacklist
+	}
+	if ours.SafeMode != base.SafeMode {
+		merged.SafeMode = ours.SafeMode
+	}
+	if ours.Credentials != base.Credentials {
+		merged.Credentials = ours.Credentials
+	}
+
+	if !slices.Equal(ours.History, base.History) {
+		history := slices.Clone(ours.History)
+		for _, q := range theirs.History {
+			if len(history) < maxHistory && !slices.Contains(ours.History, q) {
+				history = append(history, q)
+			}
+		}
+		merged.History = history
+	}
+
+	for _, b := range base.SavedSearches {
+		if findSavedSearch(ours.SavedSearches, b.Name) < 0 {
+			if i := findSavedSearch(merged.SavedSearches, b.Name); i >= 0 {
+				merged.SavedSearches = slices.Delete(merged.SavedSearches, i, i+1)
+			}
+		}
+	}
+	for _, o := range ours.SavedSearches {
+		if i := findSavedSearch(base.SavedSearches, o.Name); i >= 0 && base.SavedSearches[i] == o {
+			continue
+		}
+		if i := findSavedSearch(merged.SavedSearches, o.Name); i >= 0 {
+			merged.SavedSearches[i] = o
+		} else {
+			merged.SavedSearches = append(merged.SavedSearches, o)
+		}
+	}
+	return merged
+}
+
+// findSavedSearch returns the index of the saved search called name, or -1.
+func findSavedSearch(saved []savedSearch, name string) int {
+	return slices.IndexFunc(saved, func(s savedSearch) bool {
+		return strings.EqualFold(s.Name, name)
+	})
+}
+
+// cloneSettings copies us so that changing one doesn't change the other.
+func cloneSettings(us userSettings) userSettings {
+	us.History = slices.Clone(us.History)
+	us.SavedSearches = slices.Clone(us.SavedSearches)
+	return us
+}
+
+// seal encrypts creds for user id. The id is authenticated along with them,
+// so sealed credentials can't be copied to another user's settings.
+func (s *settingsStore) seal(id string, creds credentials) (string, error) {
+	plain, err := json.Marshal([2]string{creds.username, creds.apiKey})
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, plain, []byte(id))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts credentials sealed for user id.
+func (s *settingsStore) open(id, sealed string) (credentials, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return credentials{}, err
+	}
+	if len(data) < s.aead.NonceSize() {
+		return credentials{}, errors.New("sealed credentials are too short")
+	}
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return credentials{}, err
+	}
+	var fields [2]string
+	if err := json.Unmarshal(plain, &fields); err != nil {
+		return credentials{}, err
+	}
+	return credentials{username: fields[0], apiKey: fields[1]}, nil
+}
+
+// loadSettings applies the settings remembered for the session's user.
+// An image mode requested through the environment wins over the saved one.
+func (m *model) loadSettings(s ssh.Session) {
+	m.userID = userID(s)
+	if settings == nil || m.userID == "" {
+		return
+	}
+	us, err := settings.load(m.userID)
+	if err != nil {
+		log.Printf("Failed to load settings for %s: %v", fingerprint(s), err)
+		return
+	}
+	m.settings = us
+	m.savedSettings = cloneSettings(us)
+	log.Printf("Loaded settings for %s", fingerprint(s))
+
+	m.showFullImage = us.ShowFullImage
+	m.setBlacklist(us.Blacklist)
+	m.safeMode = m.safeMode || us.SafeMode
+	if proto, ok := parseImageProtocol(us.ImageProtocol); ok && lookupEnv(s.Environ(), "E6TEA_IMAGE_PROTOCOL") == "" {
+		m.imageProtocol = proto
+	}
+	if us.Credentials != "" {
+		creds, err := settings.open(m.userID, us.Credentials)
+		if err != nil {
+			log.Printf("Failed to decrypt saved e621 login: %v", err)
+			return
+		}
+		m.restoreLogin(creds)
+	}
+}
+
+// saveSettings remembers m.settings, if the user can be recognised. Changes
+// other sessions of the same user saved in the meantime are kept.
+func (m *model) saveSettings() {
+	if settings == nil || m.userID == "" {
+		return
+	}
+	merged, err := settings.update(m.userID, m.savedSettings, m.settings)
+	if err != nil {
+		log.Printf("Failed to save settings: %v", err)
+		return
+	}
+	m.settings = merged
+	m.savedSettings = cloneSettings(merged)
+}
+
+// rememberLogin stores or, with nil, forgets the user's credentials.
+func (m *model) rememberLogin(creds *credentials) {
+	if settings == nil || m.userID == "" {
+		return
+	}
+	m.settings.Credentials = ""
+	if creds != nil {
+		sealed, err := settings.seal(m.userID, *creds)
+		if err != nil {
+			log.Printf("Failed to encrypt e621 login: %v", err)
+			return
+		}
+		m.settings.Credentials = sealed
+	}
+	m.saveSettings()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestMergeSettings(t *testing.T) {
+	base := userSettings{
+		Blacklist:     "gore",
+		History:       []string{"b", "a"},
+		SavedSearches: []savedSearch{{"Cats", "cat"}, {"Dogs", "dog"}},
+	}
+	// We searched for c, turned on safe mode and deleted "Dogs".
+	ours := userSettings{
+		Blacklist:     "gore",
+		SafeMode:      true,
+		History:       []string{"c", "b", "a"},
+		SavedSearches: []savedSearch{{"Cats", "cat"}},
+	}
+	// Meanwhile another session searched for d, changed the blacklist and
+	// saved "Foxes".
+	theirs := userSettings{
+		Blacklist:     "gore\nscat",
+		History:       []string{"d", "b", "a"},
+		SavedSearches: []savedSearch{{"Cats", "cat"}, {"Dogs", "dog"}, {"Foxes", "fox"}},
+	}
+
+	got := mergeSettings(base, ours, theirs)
+	want := userSettings{
+		Blacklist:     "gore\nscat",
+		SafeMode:      true,
+		History:       []string{"c", "b", "a", "d"},
+		SavedSearches: []savedSearch{{"Cats", "cat"}, {"Foxes", "fox"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeSettings() = %+v, want %+v", got, want)
+	}
+
+	// Unchanged settings leave the saved ones alone.
+	if got := mergeSettings(base, base, theirs); !reflect.DeepEqual(got, theirs) {
+		t.Errorf("mergeSettings() without changes = %+v, want %+v", got, theirs)
+	}
+}
+
+func TestSettingsUpdateConcurrent(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	store, err := newSettingsStore(t.TempDir(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const sessions = 20
+	var wg sync.WaitGroup
+	for i := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("search %d", i)
+			ours := userSettings{SavedSearches: []savedSearch{{name, name}}}
+			if _, err := store.update("user", userSettings{}, ours); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	us, err := store.load("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(us.SavedSearches) != sessions {
+		t.Errorf("got %d saved searches, want %d", len(us.SavedSearches), sessions)
+	}
+}
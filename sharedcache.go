@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// --- Shared Upstream Cache ---
+//
+// All sessions share one HTTP client and one cache of upstream responses, so
+// popular pages and images are only fetched from e621 once.
+
+// cacheKind groups responses that expire after the same time.
+type cacheKind int
+
+const (
+	cachePosts cacheKind = iota
+	cacheUserPosts
+	cacheImages
+	cacheTags
+)
+
+// ttl returns how long responses of this kind stay fresh.
+func (k cacheKind) ttl() time.Duration {
+	switch k {
+	case cachePosts, cacheUserPosts:
+		return time.Minute
+	case cacheImages:
+		// Files are addressed by their md5, so they never change.
+		return 24 * time.Hour
+	case cacheTags:
+		// Post counts change slowly and are only used as a hint.
+		return time.Hour
+	default:
+		return 0
+	}
+}
+
+// The largest responses that are read, by kind. Bigger ones aren't worth
+// holding in memory or on disk.
+const (
+	maxAPIResponseSize = 8 << 20
+	maxImageSize       = 64 << 20
+)
+
+// onDisk reports whether responses of this kind may be written to the disk
+// tier. Responses fetched with a user's credentials stay in memory.
+func (k cacheKind) onDisk() bool {
+	return k != cacheUserPosts
+}
+
+// upstreamClient is the HTTP client shared by all sessions.
+var upstreamClient = &http.Client{Timeout: 30 * time.Second}
+
+// upstreamCache is replaced by setupCache with one configured from the
+// environment when the server starts.
+var upstreamCache = newSharedCache(64<<20, "", 0)
+
+// statusError is returned for unsuccessful upstream responses.
+type statusError struct {
+	status     string
+	code       int
+	body       string
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("status %s", e.status)
+	}
+	return fmt.Sprintf("status %s: %s", e.status, e.body)
+}
+
+// errTooLarge is returned for response bodies over the size limit.
+var errTooLarge = errors.New("response is too large")
+
+// fetchBody performs req and returns the response body if it was successful
+// and no longer than limit bytes.
+func fetchBody(ctx context.Context, client *http.Client, req *http.Request, limit int64) ([]byte, error) {
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &statusError{
+			status:     resp.Status,
+			code:       resp.StatusCode,
+			body:       string(body),
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("%w: %d bytes, the limit is %d", errTooLarge, resp.ContentLength, limit)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: over the limit of %d bytes", errTooLarge, limit)
+	}
+	return data, nil
+}
+
+type cachedResponse struct {
+	data    []byte
+	fetched time.Time
+}
+
+// sharedCache is a two tier cache of response bodies: an in-memory LRU in
+// front of an optional directory on disk. Concurrent requests for the same
+// key are coalesced into a single upstream request.
+type sharedCache struct {
+	memory *lruCache[*cachedResponse]
+
+	mu       sync.Mutex
+	inflight map[string]*inflightFetch
+
+	dir       string // Empty disables the disk tier.
+	diskLimit int64
+	diskSize  atomic.Int64
+	sweeping  atomic.Bool
+}
+
+func newSharedCache(memoryLimit int, dir string, diskLimit int64) *sharedCache {
+	c := &sharedCache{
+		memory: newLRUCache(memoryLimit, func(r *cachedResponse) int {
+			return len(r.data)
+		}),
+		inflight:  map[string]*inflightFetch{},
+		dir:       dir,
+		diskLimit: diskLimit,
+	}
+	if dir == "" {
+		return c
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("Disabling disk cache, could not create %s: %v", dir, err)
+		c.dir = ""
+		return c
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			c.diskSize.Add(info.Size())
+		}
+	}
+	return c
+}
+
+// setupCache configures the shared cache from the environment.
+// E6TEA_CACHE_DIR defaults to "cache", E6TEA_CACHE_MEMORY_MB to 256 and
+// E6TEA_CACHE_DISK_MB to 1024. A disk limit of 0 disables the disk tier.
+func setupCache() {
+	dir := os.Getenv("E6TEA_CACHE_DIR")
+	if dir == "" {
+		dir = "cache"
+	}
+	memoryMB := envInt("E6TEA_CACHE_MEMORY_MB", 256)
+	diskMB := envInt("E6TEA_CACHE_DISK_MB", 1024)
+	if diskMB <= 0 {
+		dir = ""
+	}
+	upstreamCache = newSharedCache(memoryMB<<20, dir, int64(diskMB)<<20)
+	log.Printf("Response cache: %d MB in memory, %d MB on disk in %q", memoryMB, diskMB, dir)
+}
+
+// envInt reads an integer from the environment, falling back to def.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", key, v, err)
+		return def
+	}
+	return n
+}
+
+// inflightFetch is an upstream request that one or more callers of get are
+// waiting for.
+type inflightFetch struct {
+	done    chan struct{}
+	data    []byte
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// get returns the body cached for key if it's still fresh, and otherwise
+// calls fetch to get it. Only one fetch per key runs at a time; everyone
+// else waits for its result. The fetch is cancelled once every caller
+// waiting for it has given up.
+func (c *sharedCache) get(ctx context.Context, key string, kind cacheKind, fetch func(context.Context) ([]byte, error)) ([]byte, error) {
+	if r, ok := c.memory.get(key); ok && time.Since(r.fetched) < kind.ttl() {
+		return r.data, nil
+	}
+	if r, ok := c.readDisk(key, kind); ok {
+		c.memory.put(key, r)
+		return r.data, nil
+	}
+
+	c.mu.Lock()
+	f, ok := c.inflight[key]
+	if !ok {
+		fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &inflightFetch{done: make(chan struct{}), cancel: cancel}
+		c.inflight[key] = f
+		go c.run(fetchCtx, key, kind, f, fetch)
+	}
+	f.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.data, f.err
+	case <-ctx.Done():
+		c.leave(key, f)
+		return nil, ctx.Err()
+	}
+}
+
+// run performs the fetch of f and caches its result.
+func (c *sharedCache) run(ctx context.Context, key string, kind cacheKind, f *inflightFetch, fetch func(context.Context) ([]byte, error)) {
+	defer f.cancel()
+	f.data, f.err = fetch(ctx)
+	if f.err == nil {
+		c.memory.put(key, &cachedResponse{data: f.data, fetched: time.Now()})
+		if kind.onDisk() {
+			c.writeDisk(key, f.data)
+		}
+	}
+
+	c.mu.Lock()
+	if c.inflight[key] == f {
+		delete(c.inflight, key)
+	}
+	c.mu.Unlock()
+	close(f.done)
+}
+
+// leave stops waiting for f, cancelling it when nobody else is. A later
+// request for the same key starts a new fetch.
+func (c *sharedCache) leave(key string, f *inflightFetch) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f.waiters--
+	if f.waiters > 0 {
+		return
+	}
+	f.cancel()
+	if c.inflight[key] == f {
+		delete(c.inflight, key)
+	}
+}
+
+// invalidate drops key from both tiers.
+func (c *sharedCache) invalidate(key string) {
+	c.memory.remove(key)
+	if c.dir != "" {
+		os.Remove(c.diskPath(key))
+	}
+}
+
+func (c *sharedCache) diskPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *sharedCache) readDisk(key string, kind cacheKind) (*cachedResponse, bool) {
+	if c.dir == "" || !kind.onDisk() {
+		return nil, false
+	}
+	path := c.diskPath(key)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) >= kind.ttl() {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return &cachedResponse{data: data, fetched: info.ModTime()}, true
+}
+
+// writeDisk stores data atomically and sweeps the directory in the
+// background if it grew past its limit.
+func (c *sharedCache) writeDisk(key string, data []byte) {
+	if c.dir == "" || int64(len(data)) > c.diskLimit {
+		return
+	}
+	path := c.diskPath(key)
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		log.Printf("Failed to write cache entry: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Failed to write cache entry: %v", err)
+		return
+	}
+	if info, err := os.Stat(path); err == nil {
+		c.diskSize.Add(-info.Size())
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Failed to write cache entry: %v", err)
+		return
+	}
+
+	if c.diskSize.Add(int64(len(data))) > c.diskLimit && c.sweeping.CompareAndSwap(false, true) {
+		go c.sweepDisk()
+	}
+}
+
+// sweepDisk removes the oldest entries until the disk tier is back under 90%
+// of its limit.
+func (c *sharedCache) sweepDisk() {
+	defer c.sweeping.Store(false)
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Printf("Failed to sweep cache: %v", err)
+		return
+	}
+	var infos []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			infos = append(infos, info)
+			total += info.Size()
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	target := c.diskLimit * 9 / 10
+	removed := 0
+	for _, info := range infos {
+		if total <= target {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, info.Name())); err == nil {
+			total -= info.Size()
+			removed++
+		}
+	}
+	c.diskSize.Store(total)
+	log.Printf("Swept %d entries from the disk cache, %d MB left", removed, total>>20)
+}
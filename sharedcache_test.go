@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingFetch returns a fetch that waits for release or its context, and
+// reports on cancelled when the context ends first.
+func blockingFetch(release <-chan struct{}, cancelled chan<- struct{}) func(context.Context) ([]byte, error) {
+	return func(ctx context.Context) ([]byte, error) {
+		select {
+		case <-release:
+			return []byte("data"), nil
+		case <-ctx.Done():
+			close(cancelled)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func TestSharedCacheCancelsAbandonedFetch(t *testing.T) {
+	c := newSharedCache(1<<20, "", 0)
+	cancelled := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errc := make(chan error)
+	go func() {
+		_, err := c.get(ctx, "key", cachePosts, blockingFetch(nil, cancelled))
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("get() error = %v, want context.Canceled", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the fetch wasn't cancelled after its only caller left")
+	}
+}
+
+func TestSharedCacheKeepsJoinedFetch(t *testing.T) {
+	c := newSharedCache(1<<20, "", 0)
+	release := make(chan struct{})
+	cancelled := make(chan struct{})
+	fetch := blockingFetch(release, cancelled)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := c.get(ctx, "key", cachePosts, fetch)
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	second := make(chan []byte)
+	go func() {
+		data, _ := c.get(context.Background(), "key", cachePosts, func(context.Context) ([]byte, error) {
+			t.Error("a second fetch was started")
+			return nil, nil
+		})
+		second <- data
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("first get() error = %v, want context.Canceled", err)
+	}
+	close(release)
+	if data := <-second; string(data) != "data" {
+		t.Errorf("second get() = %q, want %q", data, "data")
+	}
+	select {
+	case <-cancelled:
+		t.Error("the fetch was cancelled while a caller was still waiting")
+	default:
+	}
+
+	// The result is cached for later callers.
+	data, err := c.get(context.Background(), "key", cachePosts, func(context.Context) ([]byte, error) {
+		return nil, errors.New("fetched again")
+	})
+	if err != nil || string(data) != "data" {
+		t.Errorf("cached get() = %q, %v", data, err)
+	}
+}
+
+func TestFetchBodyLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("chunked") {
+			w.(http.Flusher).Flush() // No Content-Length.
+		}
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		url     string
+		limit   int64
+		wantErr bool
+	}{
+		{"/", 100, false},
+		{"/", 99, true},
+		{"/?chunked", 100, false},
+		{"/?chunked", 99, true},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", srv.URL+tt.url, nil)
+		data, err := fetchBody(context.Background(), srv.Client(), req, tt.limit)
+		if tt.wantErr {
+			if !errors.Is(err, errTooLarge) {
+				t.Errorf("fetchBody(%s, %d) error = %v, want errTooLarge", tt.url, tt.limit, err)
+			}
+			continue
+		}
+		if err != nil || len(data) != 100 {
+			t.Errorf("fetchBody(%s, %d) = %d bytes, %v", tt.url, tt.limit, len(data), err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+	"strings"
+)
+
+// --- Sixel Graphics ---
+
+const (
+	// sixelMaxColors is the number of colour registers most sixel terminals
+	// support.
+	sixelMaxColors = 256
+	// sixelAlphaThreshold is the alpha below which a pixel is left transparent.
+	sixelAlphaThreshold = 128
+)
+
+// encodeSixel scales img to fit box and returns the escape codes that move
+// the cursor to the centred position and draw the image there as sixels.
+func encodeSixel(img image.Image, box placement, cell cellSize) (string, error) {
+	b := img.Bounds()
+	fit := fitImage(b.Dx(), b.Dy(), box, cell)
+	if fit.cols == 0 || fit.rows == 0 {
+		return "", fmt.Errorf("no room to draw image in %dx%d cells", box.cols, box.rows)
+	}
+
+	scaled := scaleImage(img, fit.pxW, fit.pxH)
+	paletted := image.NewPaletted(scaled.Rect, quantize(scaled, sixelMaxColors))
+	draw.FloydSteinberg.Draw(paletted, scaled.Rect, scaled, image.Point{})
+
+	return moveCursor(fit.x, fit.y) + sixelData(paletted, scaled), nil
+}
+
+// sixelData serialises a paletted image as a sixel DCS sequence. The alpha
+// channel of the original image decides which pixels are left untouched.
+func sixelData(img *image.Paletted, alpha *image.RGBA) string {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+
+	var sb strings.Builder
+	// P2=1 keeps pixels we don't paint transparent. The raster attributes
+	// tell the terminal the pixel aspect ratio and image size up front.
+	sb.WriteString("\x1bP0;1;0q")
+	fmt.Fprintf(&sb, "\"1;1;%d;%d", w, h)
+	for i, c := range img.Palette {
+		r, g, b, _ := c.RGBA()
+		fmt.Fprintf(&sb, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, b*100/0xffff)
+	}
+
+	bits := make([]byte, w*len(img.Palette))
+	used := make([]bool, len(img.Palette))
+	for band := 0; band < h; band += 6 {
+		clear(bits)
+		clear(used)
+		for dy := 0; dy < 6 && band+dy < h; dy++ {
+			y := band + dy
+			for x := 0; x < w; x++ {
+				if alpha.Pix[y*alpha.Stride+x*4+3] < sixelAlphaThreshold {
+					continue
+				}
+				idx := int(img.Pix[y*img.Stride+x])
+				bits[idx*w+x] |= 1 << dy
+				used[idx] = true
+			}
+		}
+
+		first := true
+		for idx, ok := range used {
+			if !ok {
+				continue
+			}
+			if !first {
+				sb.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(&sb, "#%d", idx)
+			writeSixelRow(&sb, bits[idx*w:(idx+1)*w])
+		}
+		sb.WriteByte('-')
+	}
+
+	sb.WriteString("\x1b\\")
+	return sb.String()
+}
+
+// writeSixelRow writes one colour's sixels for a band, run-length encoding
+// repeated characters.
+func writeSixelRow(sb *strings.Builder, row []byte) {
+	for i := 0; i < len(row); {
+		j := i + 1
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		ch := byte(63 + row[i])
+		if n := j - i; n > 3 {
+			fmt.Fprintf(sb, "!%d%c", n, ch)
+		} else {
+			for ; n > 0; n-- {
+				sb.WriteByte(ch)
+			}
+		}
+		i = j
+	}
+}
+
+// --- Palette Quantization ---
+
+// colorBucket is a histogram entry for colours reduced to 5 bits per channel.
+type colorBucket struct {
+	rgb   [3]uint8
+	count int
+}
+
+// colorBox is a box in RGB space used by the median cut quantizer.
+type colorBox struct {
+	buckets []colorBucket
+}
+
+// quantize builds a palette of at most n colours for img using median cut.
+func quantize(img *image.RGBA, n int) color.Palette {
+	var hist [1 << 15]int
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		if img.Pix[i+3] < sixelAlphaThreshold {
+			continue
+		}
+		r, g, b := img.Pix[i]>>3, img.Pix[i+1]>>3, img.Pix[i+2]>>3
+		hist[int(r)<<10|int(g)<<5|int(b)]++
+	}
+
+	var buckets []colorBucket
+	for key, count := range hist {
+		if count == 0 {
+			continue
+		}
+		buckets = append(buckets, colorBucket{
+			rgb:   [3]uint8{uint8(key >> 10), uint8(key >> 5 & 31), uint8(key & 31)},
+			count: count,
+		})
+	}
+	if len(buckets) == 0 {
+		return color.Palette{color.RGBA{A: 0xff}}
+	}
+
+	boxes := []colorBox{{buckets: buckets}}
+	for len(boxes) < n {
+		// Split the box with the widest channel range.
+		best, bestAxis, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box.buckets) < 2 {
+				continue
+			}
+			axis, rng := box.widestAxis()
+			if rng > bestRange {
+				best, bestAxis, bestRange = i, axis, rng
+			}
+		}
+		if best < 0 {
+			break
+		}
+		lo, hi := boxes[best].split(bestAxis)
+		boxes[best] = lo
+		boxes = append(boxes, hi)
+	}
+
+	palette := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		palette = append(palette, box.average())
+	}
+	return palette
+}
+
+// widestAxis returns the channel with the largest spread and its range.
+func (b colorBox) widestAxis() (int, int) {
+	var lo, hi [3]uint8
+	lo = b.buckets[0].rgb
+	hi = lo
+	for _, bucket := range b.buckets[1:] {
+		for c := 0; c < 3; c++ {
+			lo[c] = min(lo[c], bucket.rgb[c])
+			hi[c] = max(hi[c], bucket.rgb[c])
+		}
+	}
+	axis := 0
+	for c := 1; c < 3; c++ {
+		if hi[c]-lo[c] > hi[axis]-lo[axis] {
+			axis = c
+		}
+	}
+	return axis, int(hi[axis] - lo[axis])
+}
+
+// split divides the box at the pixel-weighted median of the given channel.
+func (b colorBox) split(axis int) (colorBox, colorBox) {
+	sort.Slice(b.buckets, func(i, j int) bool {
+		return b.buckets[i].rgb[axis] < b.buckets[j].rgb[axis]
+	})
+	total := 0
+	for _, bucket := range b.buckets {
+		total += bucket.count
+	}
+	mid, seen := len(b.buckets)-1, 0
+	for i, bucket := range b.buckets[:len(b.buckets)-1] {
+		seen += bucket.count
+		if seen*2 >= total {
+			mid = i + 1
+			break
+		}
+	}
+	return colorBox{buckets: b.buckets[:mid]}, colorBox{buckets: b.buckets[mid:]}
+}
+
+// average returns the pixel-weighted mean colour of the box.
+func (b colorBox) average() color.Color {
+	var r, g, bl, n int
+	for _, bucket := range b.buckets {
+		r += int(bucket.rgb[0]) * bucket.count
+		g += int(bucket.rgb[1]) * bucket.count
+		bl += int(bucket.rgb[2]) * bucket.count
+		n += bucket.count
+	}
+	// Scale 5 bit channels back up to 8 bits.
+	return color.RGBA{
+		R: uint8(r * 255 / (n * 31)),
+		G: uint8(g * 255 / (n * 31)),
+		B: uint8(bl * 255 / (n * 31)),
+		A: 0xff,
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// decodeSixel parses the output of sixelData back into its palette and the
+// colour index of every pixel, -1 where nothing was painted.
+func decodeSixel(t *testing.T, s string) (color.Palette, [][]int) {
+	t.Helper()
+	body, ok := strings.CutPrefix(s, "\x1bP0;1;0q")
+	if !ok {
+		t.Fatalf("missing DCS header in %q", s)
+	}
+	body, ok = strings.CutSuffix(body, "\x1b\\")
+	if !ok {
+		t.Fatalf("missing string terminator in %q", s)
+	}
+
+	var w, h int
+	number := func() int {
+		i := 0
+		for i < len(body) && body[i] >= '0' && body[i] <= '9' {
+			i++
+		}
+		n, err := strconv.Atoi(body[:i])
+		if err != nil {
+			t.Fatalf("expected a number at %q", body)
+		}
+		body = body[i:]
+		return n
+	}
+	if !strings.HasPrefix(body, "\"1;1;") {
+		t.Fatalf("missing raster attributes in %q", body)
+	}
+	body = body[len("\"1;1;"):]
+	w = number()
+	body = body[1:]
+	h = number()
+
+	pixels := make([][]int, h)
+	for y := range pixels {
+		pixels[y] = make([]int, w)
+		for x := range pixels[y] {
+			pixels[y][x] = -1
+		}
+	}
+	var palette color.Palette
+	band, x, current := 0, 0, 0
+	for body != "" {
+		c := body[0]
+		body = body[1:]
+		switch {
+		case c == '#':
+			idx := number()
+			if strings.HasPrefix(body, ";2;") {
+				body = body[3:]
+				r := number()
+				body = body[1:]
+				g := number()
+				body = body[1:]
+				b := number()
+				for len(palette) <= idx {
+					palette = append(palette, nil)
+				}
+				palette[idx] = color.RGBA{uint8(r * 255 / 100), uint8(g * 255 / 100), uint8(b * 255 / 100), 0xff}
+			} else {
+				current = idx
+			}
+		case c == '$':
+			x = 0
+		case c == '-':
+			x = 0
+			band += 6
+		case c == '!':
+			n := number()
+			ch := body[0]
+			body = body[1:]
+			for ; n > 0; n-- {
+				paintSixel(pixels, band, x, current, ch)
+				x++
+			}
+		case c >= 63 && c <= 126:
+			paintSixel(pixels, band, x, current, c)
+			x++
+		default:
+			t.Fatalf("unexpected %q in sixel data", c)
+		}
+	}
+	return palette, pixels
+}
+
+func paintSixel(pixels [][]int, band, x, idx int, ch byte) {
+	bits := ch - 63
+	for dy := 0; dy < 6; dy++ {
+		if bits&(1<<dy) != 0 {
+			pixels[band+dy][x] = idx
+		}
+	}
+}
+
+func TestSixelRoundTrip(t *testing.T) {
+	colors := []color.RGBA{
+		{0xff, 0, 0, 0xff},
+		{0, 0xff, 0, 0xff},
+		{0, 0, 0xff, 0xff},
+		{0, 0, 0, 0}, // Transparent.
+	}
+	// 9 rows make a full band and a partial one. The partial band is all one
+	// colour, so it's run-length encoded.
+	const w, h = 8, 9
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := colors[(x/2+y)%len(colors)]
+			if y >= 6 {
+				c = colors[0]
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	palette := quantize(img, 16)
+	if len(palette) != 3 {
+		t.Fatalf("palette has %d colours, want 3: %v", len(palette), palette)
+	}
+	paletted := image.NewPaletted(img.Rect, palette)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			paletted.Set(x, y, img.RGBAAt(x, y))
+		}
+	}
+
+	out := sixelData(paletted, img)
+	if !strings.Contains(out, "!8") {
+		t.Errorf("the row of one colour isn't run-length encoded: %q", out)
+	}
+	gotPalette, pixels := decodeSixel(t, out)
+	if len(gotPalette) != len(palette) {
+		t.Fatalf("decoded %d palette entries, want %d", len(gotPalette), len(palette))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			want := img.RGBAAt(x, y)
+			idx := pixels[y][x]
+			if want.A == 0 {
+				if idx != -1 {
+					t.Errorf("transparent pixel (%d, %d) was painted with colour %d", x, y, idx)
+				}
+				continue
+			}
+			if idx < 0 {
+				t.Errorf("pixel (%d, %d) wasn't painted", x, y)
+				continue
+			}
+			if got := gotPalette[idx]; got != color.Color(want) {
+				t.Errorf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	img.SetRGBA(0, 0, color.RGBA{0xff, 0xff, 0xff, 0xff})
+	img.SetRGBA(1, 0, color.RGBA{0xff, 0xff, 0xff, 0xff})
+	img.SetRGBA(2, 0, color.RGBA{0, 0, 0, 0xff})
+	img.SetRGBA(3, 0, color.RGBA{0xff, 0, 0, 0x10}) // Transparent, ignored.
+
+	tests := []struct {
+		n    int
+		want int
+	}{
+		{1, 1},
+		{2, 2},
+		{256, 2},
+	}
+	for _, tt := range tests {
+		palette := quantize(img, tt.n)
+		if len(palette) != tt.want {
+			t.Errorf("quantize(img, %d) has %d colours, want %d", tt.n, len(palette), tt.want)
+		}
+		for _, c := range palette {
+			if r, g, b, _ := c.RGBA(); r != g || g != b {
+				t.Errorf("quantize(img, %d) picked %v from a grey image", tt.n, c)
+			}
+		}
+	}
+
+	if palette := quantize(image.NewRGBA(image.Rect(0, 0, 2, 2)), 16); len(palette) != 1 {
+		t.Errorf("a transparent image got %d colours, want 1", len(palette))
+	}
+}
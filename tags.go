@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- Tag Browser ---
+//
+// The tags popup lists the tags of the selected post grouped by category.
+// Any of them can be searched for, added to the current search or excluded
+// from it.
+
+// tagCategories are the tag categories in the order the site lists them.
+var tagCategories = []struct {
+	id    int
+	label string
+}{
+	{1, "Artist"},
+	{3, "Copyright"},
+	{4, "Character"},
+	{5, "Species"},
+	{0, "General"},
+	{7, "Meta"},
+	{8, "Lore"},
+	{6, "Invalid"},
+}
+
+type tagEntry struct {
+	name     string
+	category int
+}
+
+// tagsIn returns the post's tags of a category.
+func (p *Post) tagsIn(category int) []string {
+	switch category {
+	case 0:
+		return p.Tags.General
+	case 1:
+		return p.Tags.Artist
+	case 3:
+		return p.Tags.Copyright
+	case 4:
+		return p.Tags.Character
+	case 5:
+		return p.Tags.Species
+	case 6:
+		return p.Tags.Invalid
+	case 7:
+		return p.Tags.Meta
+	case 8:
+		return p.Tags.Lore
+	default:
+		return nil
+	}
+}
+
+// setTagList fills the tags popup with the tags of a post.
+func (m *model) setTagList(p Post) {
+	m.tagList = nil
+	for _, c := range tagCategories {
+		for _, name := range p.tagsIn(c.id) {
+			m.tagList = append(m.tagList, tagEntry{name: name, category: c.id})
+		}
+	}
+	m.tagCursor = 0
+}
+
+// updateTagList handles keys while the tags popup is open.
+func (m *model) updateTagList(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("t", "esc"))):
+		m.showTags = false
+	case key.Matches(msg, key.NewBinding(key.WithKeys("k", "up"))):
+		m.tagCursor = max(m.tagCursor-1, 0)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("j", "down"))):
+		m.tagCursor = max(min(m.tagCursor+1, len(m.tagList)-1), 0)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("pgup"))):
+		m.tagCursor = max(m.tagCursor-10, 0)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("pgdown"))):
+		m.tagCursor = max(min(m.tagCursor+10, len(m.tagList)-1), 0)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("home", "g"))):
+		m.tagCursor = 0
+	case key.Matches(msg, key.NewBinding(key.WithKeys("end", "G"))):
+		m.tagCursor = max(len(m.tagList)-1, 0)
+	case key.Matches(msg, key.NewBinding(key.WithKeys("enter"))):
+		if tag, ok := m.selectedTag(); ok {
+			return m.searchFor(tag)
+		}
+	case key.Matches(msg, key.NewBinding(key.WithKeys("+", "="))):
+		if tag, ok := m.selectedTag(); ok {
+			return m.searchFor(strings.TrimSpace(m.query + " " + tag))
+		}
+	case key.Matches(msg, key.NewBinding(key.WithKeys("-"))):
+		if tag, ok := m.selectedTag(); ok {
+			return m.searchFor(strings.TrimSpace(m.query + " -" + tag))
+		}
+	}
+	return nil
+}
+
+func (m *model) selectedTag() (string, bool) {
+	if m.tagCursor >= len(m.tagList) {
+		return "", false
+	}
+	return m.tagList[m.tagCursor].name, true
+}
+
+// searchFor replaces the current search with query, starting from its first
+// page.
+func (m *model) searchFor(query string) tea.Cmd {
+	if err := m.checkQuery(query); err != nil {
+		m.statusMessage = err.Error()
+		return clearStatusCmd(3 * time.Second)
+	}
+	m.showTags = false
+	m.showDetails = false
+	m.comments = commentsView{}
+	m.query = query
+	m.setSearchText(query)
+	m.addToHistory(query)
+	m.currentPage = 1
+	m.loading = true
+	m.posts = []Post{}
+	m.postTable.SetRows([]table.Row{})
+	m.previewViewport.SetContent("")
+	return tea.Batch(m.fetchPostsCmd(), m.spinner.Tick, tea.ClearScreen)
+}
+
+// tagListView renders the tags popup, scrolled so the cursor stays in view.
+func (m *model) tagListView(width, height int) string {
+	var lines []string
+	cursorLine := 0
+	category := -1
+	for i, tag := range m.tagList {
+		color, ok := tagCategoryColors[tag.category]
+		if !ok {
+			color = text
+		}
+		if tag.category != category {
+			category = tag.category
+			if len(lines) > 0 {
+				lines = append(lines, "")
+			}
+			label := ""
+			for _, c := range tagCategories {
+				if c.id == category {
+					label = c.label
+				}
+			}
+			lines = append(lines, lipgloss.NewStyle().Foreground(color).Bold(true).Render(label))
+		}
+
+		name := truncate(sanitizeText(tag.name), width-2)
+		if i == m.tagCursor {
+			cursorLine = len(lines)
+			lines = append(lines, "› "+lipgloss.NewStyle().Foreground(color).Reverse(true).Render(name))
+		} else {
+			lines = append(lines, "  "+lipgloss.NewStyle().Foreground(color).Render(name))
+		}
+	}
+	if len(lines) == 0 {
+		return helpStyle.Render("This post has no tags.")
+	}
+
+	offset := 0
+	if len(lines) > height {
+		offset = min(max(cursorLine-height/2, 0), len(lines)-height)
+	}
+	end := min(offset+height, len(lines))
+	return strings.Join(lines[offset:end], "\n")
+}
+
+// tagListHelp is the status bar text while the tags popup is open.
+func (m *model) tagListHelp() string {
+	help := "↑/↓: move | enter: search tag | +: add to search | -: exclude from search | t/esc: close tags popup"
+	if len(m.tagList) > 0 {
+		help = fmt.Sprintf("%d/%d | %s", m.tagCursor+1, len(m.tagList), help)
+	}
+	return help
+}
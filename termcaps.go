@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+)
+
+// --- Terminal Capability Detection ---
+
+const (
+	// probeTimeout bounds how long we wait for the client to answer queries.
+	probeTimeout = time.Second
+
+	// kittyQuery asks the terminal to validate (but not store) a 1x1 image.
+	kittyQuery = "\x1b_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\x1b\\"
+	// sixelColorsQuery is the XTSMGRAPHICS query for the number of colour
+	// registers, which only sixel capable terminals answer successfully.
+	sixelColorsQuery = "\x1b[?1;1;0S"
+	// primaryDAQuery is answered by every terminal, so its response marks the
+	// end of the replies to the queries sent before it.
+	primaryDAQuery = "\x1b[c"
+)
+
+var (
+	kittyReplyRegex       = regexp.MustCompile(`\x1b_Gi=31;([^\x1b]*)\x1b\\`)
+	sixelColorsReplyRegex = regexp.MustCompile(`\x1b\[\?1;(\d+);(\d+)S`)
+	primaryDAReplyRegex   = regexp.MustCompile(`\x1b\[\?([\d;]*)c`)
+)
+
+// terminalReport is what the client told us about itself when probed.
+type terminalReport struct {
+	kitty bool
+	sixel bool
+}
+
+// detectImageProtocol picks the image protocol for a session. An explicit
+// E6TEA_IMAGE_PROTOCOL from the client's environment wins, then the probe
+// results if any, then TERM_PROGRAM and TERM.
+func detectImageProtocol(term string, env []string, report *terminalReport) imageProtocol {
+	if name := lookupEnv(env, "E6TEA_IMAGE_PROTOCOL"); name != "" {
+		if proto, ok := parseImageProtocol(name); ok {
+			return proto
+		}
+		log.Printf("Ignoring unknown image protocol %q", name)
+	}
+
+	if report != nil {
+		switch {
+		case report.kitty:
+			return protocolKitty
+		case report.sixel:
+			return protocolSixel
+		}
+	}
+
+	switch lookupEnv(env, "TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm":
+		return protocolITerm
+	case "ghostty":
+		return protocolKitty
+	case "Apple_Terminal":
+		return protocolBlocks256
+	case "tmux":
+		return protocolBlocks
+	}
+
+	switch {
+	case term == "xterm-kitty", term == "xterm-ghostty":
+		return protocolKitty
+	case strings.HasPrefix(term, "foot"), strings.HasPrefix(term, "mlterm"):
+		return protocolSixel
+	case strings.HasPrefix(term, "wezterm"):
+		return protocolITerm
+	case term == "", term == "dumb":
+		return protocolNone
+	case term == "linux":
+		return protocolBlocks256
+	}
+
+	// tmux and screen can't pass graphics through reliably, and unknown
+	// terminals are better served by something that always works.
+	return protocolBlocks
+}
+
+// parseImageProtocol looks up a protocol by name.
+func parseImageProtocol(name string) (imageProtocol, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for p := imageProtocol(0); p < protocolCount; p++ {
+		if p.String() == name {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// lookupEnv returns the value of key in a list of KEY=value pairs.
+func lookupEnv(env []string, key string) string {
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// probingEnabled reports whether the server should query clients for their
+// graphics support. Queries delay the first frame and confuse some clients,
+// so it's opt-in via E6TEA_PROBE_TERMINAL.
+func probingEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("E6TEA_PROBE_TERMINAL"))
+	return enabled
+}
+
+// sessionInput forwards the client's input after probing. Everything is read
+// by a single goroutine, so bytes that arrive after the probe timed out end
+// up in the program instead of being lost. The goroutine stops when ctx is
+// done, even if nobody reads the input anymore.
+type sessionInput struct {
+	pending []byte
+	chunks  chan []byte
+}
+
+func newSessionInput(ctx context.Context, r io.Reader) *sessionInput {
+	in := &sessionInput{chunks: make(chan []byte, 16)}
+	go func() {
+		defer close(in.chunks)
+		for {
+			buf := make([]byte, 1024)
+			n, err := r.Read(buf)
+			if n > 0 {
+				select {
+				case in.chunks <- buf[:n]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return in
+}
+
+func (in *sessionInput) Read(p []byte) (int, error) {
+	if len(in.pending) == 0 {
+		chunk, ok := <-in.chunks
+		if !ok {
+			return 0, io.EOF
+		}
+		in.pending = chunk
+	}
+	n := copy(p, in.pending)
+	in.pending = in.pending[n:]
+	return n, nil
+}
+
+// sessionOutput serialises writes to the session. The program writes its
+// frames from the renderer's goroutine, while escape codes that aren't part
+// of a frame are written from Update, and the two mustn't interleave.
+type sessionOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (out *sessionOutput) Write(p []byte) (int, error) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	return out.w.Write(p)
+}
+
+// probeTerminal sends the kitty graphics and XTSMGRAPHICS queries followed by
+// DA1 and collects the replies until DA1 is answered or we time out. Replies
+// are stripped from the input, anything else is kept for the program.
+func probeTerminal(in *sessionInput, out io.Writer) terminalReport {
+	var report terminalReport
+	if _, err := io.WriteString(out, kittyQuery+sixelColorsQuery+primaryDAQuery); err != nil {
+		log.Printf("Failed to probe terminal: %v", err)
+		return report
+	}
+
+	var buf []byte
+	timeout := time.After(probeTimeout)
+read:
+	for !primaryDAReplyRegex.Match(buf) {
+		select {
+		case chunk, ok := <-in.chunks:
+			if !ok {
+				return report
+			}
+			buf = append(buf, chunk...)
+		case <-timeout:
+			log.Printf("Terminal probe timed out")
+			break read
+		}
+	}
+
+	if match := kittyReplyRegex.FindSubmatch(buf); match != nil {
+		report.kitty = string(match[1]) == "OK"
+	}
+	if match := sixelColorsReplyRegex.FindSubmatch(buf); match != nil {
+		report.sixel = string(match[1]) == "0"
+	}
+	if match := primaryDAReplyRegex.FindSubmatch(buf); match != nil {
+		// Attribute 4 in the DA1 reply advertises sixel graphics.
+		for _, attr := range strings.Split(string(match[1]), ";") {
+			if attr == "4" {
+				report.sixel = true
+			}
+		}
+	}
+
+	for _, re := range []*regexp.Regexp{kittyReplyRegex, sixelColorsReplyRegex, primaryDAReplyRegex} {
+		buf = re.ReplaceAll(buf, nil)
+	}
+	in.pending = append(buf, in.pending...)
+	return report
+}
+
+// negotiateImageProtocol works out the image protocol for a new session and
+// returns the reader the program should take its input from.
+func negotiateImageProtocol(s ssh.Session, term string) (imageProtocol, io.Reader) {
+	env := s.Environ()
+	if !probingEnabled() || lookupEnv(env, "E6TEA_IMAGE_PROTOCOL") != "" {
+		proto := detectImageProtocol(term, env, nil)
+		log.Printf("Picked image protocol %s for TERM=%q", proto, term)
+		return proto, s
+	}
+
+	in := newSessionInput(s.Context(), s)
+	report := probeTerminal(in, s)
+	proto := detectImageProtocol(term, env, &report)
+	log.Printf("Picked image protocol %s for TERM=%q (kitty: %t, sixel: %t)", proto, term, report.kitty, report.sixel)
+	return proto, in
+}
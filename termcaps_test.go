@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSessionInputStopsWithSession(t *testing.T) {
+	r, w := io.Pipe()
+	defer r.Close()
+	go func() {
+		for {
+			if _, err := w.Write([]byte("x")); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := newSessionInput(ctx, r)
+
+	// Nobody reads, so the reader ends up blocked on a full buffer.
+	deadline := time.Now().Add(time.Second)
+	for len(in.chunks) < cap(in.chunks) {
+		if time.Now().After(deadline) {
+			t.Fatal("the input buffer never filled up")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-in.chunks:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("the input goroutine kept running after the session ended")
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --- Votes ---
+//
+// Voting the same way twice removes the vote, the same as on the site. e621
+// doesn't tell us how the user voted on a post, so we only know about votes
+// cast during the session.
+
+// voteResponse is e621's answer to a vote, with the new score.
+type voteResponse struct {
+	Score    int `json:"score"`
+	Up       int `json:"up"`
+	Down     int `json:"down"`
+	OurScore int `json:"our_score"` // 1, -1 or 0 if the vote was removed.
+}
+
+type votedMsg struct {
+	postID int
+	resp   voteResponse
+	err    error
+}
+
+// voteCmd votes a post up (score 1) or down (score -1).
+func voteCmd(client *http.Client, host string, postID, score int) tea.Cmd {
+	return func() tea.Msg {
+		form := url.Values{"score": {strconv.Itoa(score)}}
+		endpoint := fmt.Sprintf("%s/posts/%d/votes.json", host, postID)
+		req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return votedMsg{postID: postID, err: err}
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", userAgent)
+
+		body, err := fetchAPI(context.Background(), client, req)
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			err = fmt.Errorf("API request failed with %w", statusErr)
+		}
+		if err != nil {
+			log.Printf("Failed to vote on post %d: %v", postID, err)
+			return votedMsg{postID: postID, err: err}
+		}
+
+		var resp voteResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			log.Printf("Error decoding vote response: %v", err)
+			return votedMsg{postID: postID, err: err}
+		}
+		return votedMsg{postID: postID, resp: resp}
+	}
+}
+
+// vote votes on the selected post.
+func (m *model) vote(score int) tea.Cmd {
+	if m.loading || len(m.posts) == 0 || m.postTable.Cursor() >= len(m.posts) {
+		return nil
+	}
+	if m.account == nil {
+		m.statusMessage = "Log in on the main menu to vote on posts"
+		return clearStatusCmd(2 * time.Second)
+	}
+	return voteCmd(m.httpClient, m.apiBase(), m.posts[m.postTable.Cursor()].ID, score)
+}
+
+// applyVote updates the score of the post in place.
+func (m *model) applyVote(msg votedMsg) tea.Cmd {
+	if msg.err != nil {
+		m.statusMessage = fmt.Sprintf("Couldn't vote: %v", msg.err)
+		return clearStatusCmd(3 * time.Second)
+	}
+
+	m.votes[msg.postID] = msg.resp.OurScore
+	for i := range m.posts {
+		if m.posts[i].ID == msg.postID {
+			m.posts[i].Score.Total = msg.resp.Score
+			m.posts[i].Score.Up = msg.resp.Up
+			m.posts[i].Score.Down = msg.resp.Down
+			m.updateTableRows()
+		}
+	}
+	// Don't show the old score when the page is loaded again.
+	if req, err := m.postsRequest(); err == nil {
+		upstreamCache.invalidate(m.postsCacheKey(req))
+	}
+
+	switch msg.resp.OurScore {
+	case 1:
+		m.statusMessage = fmt.Sprintf("Upvoted post #%d, score is now %d", msg.postID, msg.resp.Score)
+	case -1:
+		m.statusMessage = fmt.Sprintf("Downvoted post #%d, score is now %d", msg.postID, msg.resp.Score)
+	default:
+		m.statusMessage = fmt.Sprintf("Removed your vote on post #%d, score is now %d", msg.postID, msg.resp.Score)
+	}
+	return clearStatusCmd(2 * time.Second)
+}
+
+// voteMarker shows how the user voted on a post during this session.
+func (m *model) voteMarker(postID int) string {
+	switch m.votes[postID] {
+	case 1:
+		return "▲"
+	case -1:
+		return "▼"
+	default:
+		return ""
+	}
+}